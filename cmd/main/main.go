@@ -27,9 +27,7 @@ func main() {
 		return
 	}
 
-	roomsChannel := make(chan string)
-
-	grpcServer, err := transport.NewServer(ctx, mainLogger, roomsChannel, cfg.GRPCServerHost, cfg.RESTServerHost, cfg.GRPCServerPort, cfg.RESTServerPort)
+	grpcServer, err := transport.NewServer(ctx, mainLogger, cfg)
 	if err != nil {
 		mainLogger.Fatal(ctx, err.Error())
 		return
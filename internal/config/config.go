@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
 )
@@ -10,6 +12,77 @@ type Config struct {
 	GRPCServerPort int    `env:"GRPC_SERVER_PORT" env-default:"9090"`
 	RESTServerHost string `env:"REST_SERVER_HOST" env-default:""`
 	RESTServerPort int    `env:"REST_SERVER_PORT" env-default:"8080"`
+	// MetricsServerHost and MetricsServerPort serve /debug/vars apart from
+	// the public gateway, zero port disables it.
+	MetricsServerHost string `env:"METRICS_SERVER_HOST" env-default:"127.0.0.1"`
+	MetricsServerPort int    `env:"METRICS_SERVER_PORT" env-default:"9091"`
+
+	OutboxQueueDepth         int    `env:"OUTBOX_QUEUE_DEPTH" env-default:"256"`
+	OutboxSlowConsumerPolicy string `env:"OUTBOX_SLOW_CONSUMER_POLICY" env-default:"drop"`
+	// RoomReplayBuffer is how many of its newest messages each room keeps
+	// for clients asking to replay them.
+	RoomReplayBuffer int `env:"ROOM_REPLAY_BUFFER" env-default:"512"`
+
+	EventsSubscriberBuffer int `env:"EVENTS_SUBSCRIBER_BUFFER" env-default:"64"`
+
+	// EmptyRoomTTL is how long a room may stay empty before it is closed,
+	// zero disables the cleanup.
+	EmptyRoomTTL          time.Duration `env:"EMPTY_ROOM_TTL" env-default:"10m"`
+	EmptyRoomScanInterval time.Duration `env:"EMPTY_ROOM_SCAN_INTERVAL" env-default:"1m"`
+
+	// DefaultMaxParticipants applies to rooms created without their own
+	// limit, zero means unlimited.
+	DefaultMaxParticipants int    `env:"DEFAULT_MAX_PARTICIPANTS" env-default:"0"`
+	DefaultOverflowMode    string `env:"DEFAULT_OVERFLOW_MODE" env-default:"reject"`
+	// DefaultCandidatePolicy is the least strict ICE candidate policy any
+	// room may have: all, no-host or relay.
+	DefaultCandidatePolicy string `env:"DEFAULT_CANDIDATE_POLICY" env-default:"all"`
+
+	// InviteSecret signs invite tokens. When empty a random secret is used,
+	// so invites do not survive a restart.
+	InviteSecret     string        `env:"INVITE_SECRET" env-default:""`
+	DefaultInviteTTL time.Duration `env:"DEFAULT_INVITE_TTL" env-default:"24h"`
+
+	// AuthRequired rejects calls without a valid JWT. It is off by default
+	// so deployments without JWT keys keep starting, trusting the Username
+	// header as before.
+	AuthRequired     bool   `env:"AUTH_REQUIRED" env-default:"false"`
+	JWTHS256Secret   string `env:"JWT_HS256_SECRET" env-default:""`
+	JWTJWKSFile      string `env:"JWT_JWKS_FILE" env-default:""`
+	JWTIssuer        string `env:"JWT_ISSUER" env-default:""`
+	JWTAudience      string `env:"JWT_AUDIENCE" env-default:""`
+	JWTUsernameClaim string `env:"JWT_USERNAME_CLAIM" env-default:"preferred_username"`
+	JWTGroupsClaim   string `env:"JWT_GROUPS_CLAIM" env-default:"groups"`
+
+	// AuthPolicyFile is a YAML authorization policy, empty allows everything
+	// a user's room role permits.
+	AuthPolicyFile           string        `env:"AUTH_POLICY_FILE" env-default:""`
+	AuthPolicyReloadInterval time.Duration `env:"AUTH_POLICY_RELOAD_INTERVAL" env-default:"5s"`
+
+	// SessionGracePeriod keeps users whose stream dropped in their room so
+	// they can resume, zero makes them leave at once.
+	SessionGracePeriod  time.Duration `env:"SESSION_GRACE_PERIOD" env-default:"30s"`
+	SessionReplayBuffer int           `env:"SESSION_REPLAY_BUFFER" env-default:"256"`
+
+	// ICECandidateBuffer is how many ICE candidates a room keeps for each
+	// user that has not joined yet, for at most ICECandidateTTL.
+	ICECandidateBuffer int           `env:"ICE_CANDIDATE_BUFFER" env-default:"256"`
+	ICECandidateTTL    time.Duration `env:"ICE_CANDIDATE_TTL" env-default:"30s"`
+
+	// SDPCodecs are the allowed codecs in order of preference, empty allows
+	// every codec. SDPMaxBitrate is in kbps, zero values mean no limit.
+	SDPCodecs               []string `env:"SDP_CODECS" env-separator:","`
+	SDPMaxBitrate           int      `env:"SDP_MAX_BITRATE" env-default:"0"`
+	SDPDisallowedExtensions []string `env:"SDP_DISALLOWED_EXTENSIONS" env-separator:","`
+	SDPMaxMediaSections     int      `env:"SDP_MAX_MEDIA_SECTIONS" env-default:"0"`
+
+	// STUNURLs and TURNURLs are handed to room members as their ice servers.
+	// TURNSecret is the coturn static-auth-secret TURN credentials are
+	// derived from, they expire after TURNCredentialTTL.
+	STUNURLs          []string      `env:"STUN_URLS" env-separator:","`
+	TURNURLs          []string      `env:"TURN_URLS" env-separator:","`
+	TURNSecret        string        `env:"TURN_SECRET" env-default:""`
+	TURNCredentialTTL time.Duration `env:"TURN_CREDENTIAL_TTL" env-default:"1h"`
 }
 
 func New() (*Config, error) {
@@ -0,0 +1,41 @@
+package rooms
+
+import "context"
+
+type Action string
+
+const (
+	ActionCreateRoom Action = "create_room"
+	ActionJoinRoom   Action = "join_room"
+	ActionChat       Action = "chat"
+	ActionPublish    Action = "publish"
+	ActionModerate   Action = "moderate"
+	// ActionSignal covers ICE candidates and SDP answers, which only set
+	// up connections rather than publish media.
+	ActionSignal     Action = "signal"
+	ActionReplay     Action = "replay"
+	ActionICEServers Action = "ice_servers"
+)
+
+// Principal is who asks to perform an action.
+type Principal struct {
+	// Identity is the same as User.Identity.
+	Identity string
+	Username string
+	Groups   []string
+}
+
+type AccessRequest struct {
+	Principal Principal
+	Action    Action
+	// RoomID is empty for ActionCreateRoom.
+	RoomID   string
+	RoomName string
+}
+
+// Authorizer decides whether a principal may perform an action, on top of
+// the permissions of their role in the room. Denials are reported with
+// ErrForbidden.
+type Authorizer interface {
+	Authorize(ctx context.Context, request AccessRequest) error
+}
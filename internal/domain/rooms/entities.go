@@ -1,13 +1,339 @@
 package rooms
 
-import "github.com/google/uuid"
+import (
+	"slices"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleParticipant Role = "participant"
+	// RoleViewer only receives media and traffic, it never publishes.
+	RoleViewer Role = "viewer"
+)
 
 type User struct {
 	Id   uuid.UUID
 	Name string
+	Role Role
+	// Subject is the authenticated subject, empty when authentication is
+	// disabled.
+	Subject string
+}
+
+// Identity is what persists across a user's connections, used for room
+// ownership, moderator grants and bans.
+func (u User) Identity() string {
+	if u.Subject != "" {
+		return u.Subject
+	}
+
+	return u.Name
+}
+
+// OverflowMode decides what happens to users joining a full room.
+type OverflowMode string
+
+const (
+	OverflowReject  OverflowMode = "reject"
+	OverflowViewers OverflowMode = "viewers"
+)
+
+func (m OverflowMode) Valid() bool {
+	return m == OverflowReject || m == OverflowViewers
+}
+
+type Settings struct {
+	// MaxParticipants limits publishing users, zero means unlimited.
+	// Viewers admitted on overflow are not counted.
+	MaxParticipants int
+	Overflow        OverflowMode
+	// CandidatePolicy decides which ICE candidates of its users the room
+	// relays.
+	CandidatePolicy signaling.CandidatePolicy
 }
 
 type Room struct {
-	Name  string
-	Users []User
+	// Id is generated by the server and never changes, unlike Name.
+	Id       string
+	Name     string
+	Users    []User
+	Metadata map[string]string
+	Settings Settings
+	// Private rooms are left out of room listings and can only be joined
+	// by id.
+	Private bool
+	// PasswordHash is the bcrypt hash of the room password, nil if the
+	// room has none.
+	PasswordHash []byte
+	// Owner is the identity of the user who created the room.
+	Owner string
+	// Moderators are identities granted RoleModerator, kept across rejoins.
+	Moderators []string
+	// Banned are identities that may not join the room again.
+	Banned []string
+	// Lobby rooms hold everyone but the owner and moderators in Pending
+	// until a moderator admits them.
+	Lobby   bool
+	Pending []User
+	// Admitted are ids of pending users allowed to join, each used once.
+	Admitted []uuid.UUID
+	// Locked rooms only let the owner and moderators in.
+	Locked bool
+	// InviteUses counts joins per invite id.
+	InviteUses     map[string]int
+	RevokedInvites []string
+	// EmptySince is when the last user left the room, zero while occupied.
+	EmptySince time.Time
+	// IdempotencyKey is the key the room was created with, if any.
+	IdempotencyKey string
+}
+
+func (r Room) HasPassword() bool {
+	return len(r.PasswordHash) != 0
+}
+
+// Participants counts users that are not viewers.
+func (r Room) Participants() int {
+	count := 0
+	for _, u := range r.Users {
+		if u.Role != RoleViewer {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (r Room) User(id uuid.UUID) (User, bool) {
+	for _, u := range r.Users {
+		if u.Id == id {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+// Admit adds a user to the room, enforcing unique usernames and the room
+// capacity. It returns the user as admitted, which is a viewer if the room
+// was full and overflows into viewers.
+func (r *Room) Admit(user User) (User, error) {
+	if slices.Contains(r.Banned, user.Identity()) {
+		return User{}, ErrBanned
+	}
+
+	for _, u := range r.Users {
+		if u.Name == user.Name {
+			return User{}, ErrUsernameTaken
+		}
+	}
+
+	if user.Role == "" {
+		user.Role = r.roleOf(user.Identity())
+	}
+
+	// Users admitted from the lobby were let in by a moderator, so they may
+	// join even if the room got locked meanwhile.
+	if !user.Role.Privileged() {
+		admitted := slices.Index(r.Admitted, user.Id)
+		switch {
+		case admitted != -1:
+			r.Admitted = slices.Delete(r.Admitted, admitted, admitted+1)
+		case r.Lobby:
+			return User{}, ErrAdmissionRequired
+		case r.Locked:
+			return User{}, ErrRoomLocked
+		}
+	}
+
+	if !user.Role.Privileged() && user.Role != RoleViewer && r.Settings.MaxParticipants > 0 && r.Participants() >= r.Settings.MaxParticipants {
+		if r.Settings.Overflow != OverflowViewers {
+			return User{}, ErrRoomFull
+		}
+
+		user.Role = RoleViewer
+	}
+
+	r.Users = append(r.Users, user)
+	r.EmptySince = time.Time{}
+
+	return user, nil
+}
+
+func (r *Room) Remove(userID uuid.UUID) error {
+	index := slices.IndexFunc(r.Users, func(u User) bool { return u.Id == userID })
+	if index == -1 {
+		return ErrUserNotInRoom
+	}
+
+	r.Users = slices.Delete(r.Users, index, index+1)
+	if len(r.Users) == 0 {
+		r.EmptySince = time.Now()
+	}
+
+	return nil
+}
+
+// Waiting reports whether the user with id waits in the lobby.
+func (r Room) Waiting(id uuid.UUID) bool {
+	return slices.ContainsFunc(r.Pending, func(u User) bool { return u.Id == id })
+}
+
+// NeedsAdmission reports whether user has to wait in the lobby.
+func (r Room) NeedsAdmission(user User) bool {
+	role := user.Role
+	if role == "" {
+		role = r.roleOf(user.Identity())
+	}
+
+	return r.Lobby && !role.Privileged()
+}
+
+// AdmitInvited admits a user joining with invite, counting one of its uses.
+func (r *Room) AdmitInvited(user User, invite Invite) (User, error) {
+	if slices.Contains(r.RevokedInvites, invite.Id) {
+		return User{}, ErrInviteRevoked
+	}
+
+	if invite.MaxUses > 0 && r.InviteUses[invite.Id] >= invite.MaxUses {
+		return User{}, ErrInviteExhausted
+	}
+
+	admitted, err := r.Admit(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	if r.InviteUses == nil {
+		r.InviteUses = make(map[string]int)
+	}
+	r.InviteUses[invite.Id]++
+
+	return admitted, nil
+}
+
+func (r *Room) RevokeInvite(inviteID string) {
+	if !slices.Contains(r.RevokedInvites, inviteID) {
+		r.RevokedInvites = append(r.RevokedInvites, inviteID)
+	}
+}
+
+// Enqueue puts a user into the lobby.
+func (r *Room) Enqueue(user User) error {
+	if slices.Contains(r.Banned, user.Identity()) {
+		return ErrBanned
+	}
+
+	if r.Locked {
+		return ErrRoomLocked
+	}
+
+	for _, u := range slices.Concat(r.Users, r.Pending) {
+		if u.Name == user.Name {
+			return ErrUsernameTaken
+		}
+	}
+
+	r.Pending = append(r.Pending, user)
+
+	return nil
+}
+
+// Dequeue takes a user out of the lobby, allowing them to join once if
+// admit is set. Otherwise an admission the user has not used yet is
+// dropped as well, so users admitted after they left cannot join later.
+func (r *Room) Dequeue(userID uuid.UUID, admit bool) (User, error) {
+	admitted := -1
+	if !admit {
+		admitted = slices.Index(r.Admitted, userID)
+		if admitted != -1 {
+			r.Admitted = slices.Delete(r.Admitted, admitted, admitted+1)
+		}
+	}
+
+	index := slices.IndexFunc(r.Pending, func(u User) bool { return u.Id == userID })
+	if index == -1 && admitted != -1 {
+		return User{Id: userID}, nil
+	}
+	if index == -1 {
+		return User{}, ErrNotWaiting
+	}
+
+	user := r.Pending[index]
+	r.Pending = slices.Delete(r.Pending, index, index+1)
+
+	if admit {
+		r.Admitted = append(r.Admitted, userID)
+	}
+
+	return user, nil
+}
+
+// Kick removes a user from the room, banning their identity as well if ban
+// is set. The owner cannot be kicked.
+func (r *Room) Kick(userID uuid.UUID, ban bool) (User, error) {
+	user, ok := r.User(userID)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	if user.Role == RoleOwner {
+		return User{}, ErrForbidden
+	}
+
+	if ban && !slices.Contains(r.Banned, user.Identity()) {
+		r.Banned = append(r.Banned, user.Identity())
+	}
+
+	return user, r.Remove(userID)
+}
+
+// SetRole changes the role of a user in the room. Moderator grants are kept
+// by identity, so they survive rejoins. The owner's role cannot change.
+func (r *Room) SetRole(userID uuid.UUID, role Role) (User, error) {
+	if !role.Valid() || role == RoleOwner {
+		return User{}, ErrInvalidRole
+	}
+
+	index := slices.IndexFunc(r.Users, func(u User) bool { return u.Id == userID })
+	if index == -1 {
+		return User{}, ErrUserNotInRoom
+	}
+
+	user := r.Users[index]
+	if user.Role == RoleOwner {
+		return User{}, ErrInvalidRole
+	}
+
+	r.Moderators = slices.DeleteFunc(r.Moderators, func(identity string) bool { return identity == user.Identity() })
+	if role == RoleModerator {
+		r.Moderators = append(r.Moderators, user.Identity())
+	}
+
+	user.Role = role
+	r.Users[index] = user
+
+	return user, nil
+}
+
+// Moderates reports whether identity may moderate the room, whether or not
+// it is in the room right now.
+func (r Room) Moderates(identity string) bool {
+	return r.roleOf(identity).Can(PermissionModerate)
+}
+
+func (r Room) roleOf(identity string) Role {
+	switch {
+	case r.Owner != "" && identity == r.Owner:
+		return RoleOwner
+	case slices.Contains(r.Moderators, identity):
+		return RoleModerator
+	default:
+		return RoleParticipant
+	}
 }
@@ -0,0 +1,28 @@
+package rooms
+
+import "errors"
+
+var (
+	ErrRoomNotFound  = errors.New("no such room with given name")
+	ErrRoomExists    = errors.New("room already exists")
+	ErrUserNotInRoom = errors.New("no such user in room")
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrRoomNotEmpty  = errors.New("room is not empty")
+	ErrRoomClosed    = errors.New("room has been closed")
+	ErrRoomFull      = errors.New("room is full")
+	ErrWrongPassword = errors.New("wrong room password")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrForbidden     = errors.New("not allowed for this role")
+	ErrKicked        = errors.New("kicked from room")
+	ErrBanned        = errors.New("banned from room")
+	ErrRoomLocked    = errors.New("room is locked")
+
+	ErrInviteInvalid   = errors.New("invalid invite")
+	ErrInviteExpired   = errors.New("invite has expired")
+	ErrInviteRevoked   = errors.New("invite has been revoked")
+	ErrInviteExhausted = errors.New("invite has no uses left")
+
+	ErrAdmissionRequired = errors.New("room requires admission by a moderator")
+	ErrNotWaiting        = errors.New("no such user in lobby")
+	ErrJoinDenied        = errors.New("join request denied")
+)
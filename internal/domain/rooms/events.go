@@ -0,0 +1,54 @@
+package rooms
+
+import (
+	"maps"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+)
+
+type EventType string
+
+const (
+	EventRoomCreated         EventType = "created"
+	EventRoomClosed          EventType = "closed"
+	EventParticipantsChanged EventType = "participants_changed"
+	EventRoomLocked          EventType = "locked"
+	EventRoomUnlocked        EventType = "unlocked"
+	EventMetadataUpdated     EventType = "metadata_updated"
+)
+
+// Event describes a change in a room's lifecycle. It carries the room state
+// after the change, so listeners never need to query the repository.
+type Event struct {
+	Type         EventType
+	RoomID       string
+	Room         string
+	Participants int
+	Metadata     map[string]string
+	// Private events must not reach public room listings.
+	Private           bool
+	PasswordProtected bool
+	Locked            bool
+	CandidatePolicy   signaling.CandidatePolicy
+}
+
+func NewEvent(eventType EventType, room Room) Event {
+	return Event{
+		Type:         eventType,
+		RoomID:       room.Id,
+		Room:         room.Name,
+		Participants: len(room.Users),
+		Metadata:     maps.Clone(room.Metadata),
+		Private:      room.Private,
+
+		PasswordProtected: room.HasPassword(),
+		Locked:            room.Locked,
+		CandidatePolicy:   room.Settings.CandidatePolicy,
+	}
+}
+
+// Publisher delivers room events to whoever is listening. Publish must not
+// block, so room operations never depend on listeners.
+type Publisher interface {
+	Publish(event Event)
+}
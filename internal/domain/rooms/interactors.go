@@ -1,56 +1,580 @@
 package rooms
 
 import (
-	"github.com/gitgernit/videochat-rooms/pkg/logger"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"context"
+	"errors"
+	"slices"
 	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// Config holds server-wide defaults for rooms.
+type Config struct {
+	DefaultMaxParticipants int
+	DefaultOverflow        OverflowMode
+	DefaultInviteTTL       time.Duration
+	// DefaultCandidatePolicy applies to every room, rooms may only make it
+	// stricter.
+	DefaultCandidatePolicy signaling.CandidatePolicy
+}
+
 type Interactor struct {
-	logger          logger.Logger
-	repository      Repository
-	newRoomsChannel chan string
+	logger     logger.Logger
+	repository Repository
+	publisher  Publisher
+	invites    InviteCodec
+	config     Config
 }
 
-func NewInteractor(logger logger.Logger, repository Repository, newRoomsChannel chan string) Interactor {
+func NewInteractor(logger logger.Logger, repository Repository, publisher Publisher, invites InviteCodec, config Config) Interactor {
 	return Interactor{
-		logger:          logger,
-		repository:      repository,
-		newRoomsChannel: newRoomsChannel,
+		logger:     logger,
+		repository: repository,
+		publisher:  publisher,
+		invites:    invites,
+		config:     config,
+	}
+}
+
+type CreateRoomParams struct {
+	Name           string
+	IdempotencyKey string
+	// Settings left zero are filled from Config.
+	Settings Settings
+	// MaxParticipants overrides Settings.MaxParticipants when set, since a
+	// zero there cannot ask for an unlimited room.
+	MaxParticipants *int
+	Private         bool
+	// Password is kept only as a bcrypt hash, empty means no password.
+	Password string
+	// Owner is the identity of the creator, who joins as RoleOwner.
+	Owner string
+	// Lobby makes users wait for a moderator to admit them.
+	Lobby bool
+}
+
+// Credentials prove a user may join a room. An invite token replaces the
+// room password.
+type Credentials struct {
+	Password string
+	Invite   string
+}
+
+// CreateRoom creates a room with a fresh id. Retrying with the same
+// idempotency key returns the room created by the first attempt.
+func (i Interactor) CreateRoom(ctx context.Context, params CreateRoomParams) (Room, error) {
+	id := uuid.NewString()
+
+	settings := params.Settings
+	switch {
+	case params.MaxParticipants != nil:
+		settings.MaxParticipants = *params.MaxParticipants
+	case settings.MaxParticipants == 0:
+		settings.MaxParticipants = i.config.DefaultMaxParticipants
+	}
+	if settings.Overflow == "" {
+		settings.Overflow = i.config.DefaultOverflow
 	}
+	if settings.Overflow == "" {
+		settings.Overflow = OverflowReject
+	}
+	settings.CandidatePolicy = settings.CandidatePolicy.Stricter(i.config.DefaultCandidatePolicy)
+	if settings.CandidatePolicy == "" {
+		settings.CandidatePolicy = signaling.CandidatesAll
+	}
+
+	var passwordHash []byte
+	if params.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return Room{}, err
+		}
+		passwordHash = hash
+	}
+
+	room, err := i.repository.CreateRoom(ctx, Room{
+		Id:             id,
+		Name:           params.Name,
+		Settings:       settings,
+		Private:        params.Private,
+		PasswordHash:   passwordHash,
+		Owner:          params.Owner,
+		Lobby:          params.Lobby,
+		IdempotencyKey: params.IdempotencyKey,
+	})
+	if err != nil {
+		return Room{}, err
+	}
+
+	// A replayed request gets the existing room, which was announced already.
+	if room.Id == id {
+		i.publisher.Publish(NewEvent(EventRoomCreated, room))
+	}
+
+	return room, nil
 }
 
-func (i Interactor) CreateRoom(name string) error {
-	err := i.repository.CreateRoom(name)
+func (i Interactor) RenameRoom(ctx context.Context, id string, name string) error {
+	room, err := i.repository.RenameRoom(ctx, id, name)
 	if err != nil {
 		return err
 	}
 
-	select {
-	case i.newRoomsChannel <- name:
-		return nil
-	case <-time.After(5 * time.Second):
-		return status.Error(codes.Unavailable, "couldnt send the room to a coordinator")
+	i.publisher.Publish(NewEvent(EventMetadataUpdated, room))
+
+	return nil
+}
+
+// JoinRoom admits a user into a room and returns the user as admitted. A
+// full room rejects the user with ErrRoomFull, unless it overflows into
+// viewers, in which case the returned user has RoleViewer.
+func (i Interactor) JoinRoom(ctx context.Context, id string, user User, credentials Credentials) (User, error) {
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, invite, err := i.authenticate(room, user, credentials)
+	if err != nil {
+		return User{}, err
+	}
+
+	if invite != nil {
+		room, err = i.repository.JoinRoomWithInvite(ctx, id, user, *invite)
+	} else {
+		room, err = i.repository.JoinRoom(ctx, id, user)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	i.publisher.Publish(NewEvent(EventParticipantsChanged, room))
+
+	admitted, ok := room.User(user.Id)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	return admitted, nil
+}
+
+// SetRole changes the role of target on behalf of actor, who must be
+// allowed to manage roles.
+func (i Interactor) SetRole(ctx context.Context, id string, actor uuid.UUID, target uuid.UUID, role Role) (User, error) {
+	if err := i.authorize(ctx, id, actor, PermissionManageRoles); err != nil {
+		return User{}, err
+	}
+
+	room, err := i.repository.SetUserRole(ctx, id, target, role)
+	if err != nil {
+		return User{}, err
 	}
+
+	user, ok := room.User(target)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	return user, nil
 }
 
-func (i Interactor) JoinRoom(name string, user User) error {
-	err := i.repository.JoinRoom(name, user)
+// SetRoomLocked locks or unlocks a room on behalf of actor, who must be
+// allowed to moderate. Locked rooms reject new joins with ErrRoomLocked.
+func (i Interactor) SetRoomLocked(ctx context.Context, id string, actor uuid.UUID, locked bool) error {
+	if err := i.authorize(ctx, id, actor, PermissionModerate); err != nil {
+		return err
+	}
+
+	room, err := i.repository.SetRoomLocked(ctx, id, locked)
+	if err != nil {
+		return err
+	}
+
+	eventType := EventRoomUnlocked
+	if locked {
+		eventType = EventRoomLocked
+	}
+	i.publisher.Publish(NewEvent(eventType, room))
+
+	return nil
+}
+
+// EnterLobby puts a user into the room's lobby, where they wait until
+// AdmitUser, after which they may JoinRoom, or DenyUser.
+func (i Interactor) EnterLobby(ctx context.Context, id string, user User, credentials Credentials) error {
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := i.authenticate(room, user, credentials); err != nil {
+		return err
+	}
+
+	_, err = i.repository.EnqueueUser(ctx, id, user)
+	return err
+}
+
+// LeaveLobby takes a user who gave up waiting out of the lobby, dropping
+// their admission if they were admitted but did not join.
+func (i Interactor) LeaveLobby(ctx context.Context, id string, userID uuid.UUID) error {
+	_, err := i.repository.DequeueUser(ctx, id, userID, false)
 	return err
 }
 
-func (i Interactor) LeaveRoom(name string, user User) error {
-	err := i.repository.LeaveRoom(name, user)
+// AdmitUser lets a waiting user join the room on behalf of actor, who must
+// be allowed to moderate.
+func (i Interactor) AdmitUser(ctx context.Context, id string, actor uuid.UUID, target uuid.UUID) (User, error) {
+	return i.dequeue(ctx, id, actor, target, true)
+}
+
+// DenyUser rejects a waiting user on behalf of actor, who must be allowed
+// to moderate.
+func (i Interactor) DenyUser(ctx context.Context, id string, actor uuid.UUID, target uuid.UUID) (User, error) {
+	return i.dequeue(ctx, id, actor, target, false)
+}
+
+func (i Interactor) dequeue(ctx context.Context, id string, actor uuid.UUID, target uuid.UUID, admit bool) (User, error) {
+	if err := i.authorize(ctx, id, actor, PermissionModerate); err != nil {
+		return User{}, err
+	}
+
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	index := slices.IndexFunc(room.Pending, func(u User) bool { return u.Id == target })
+	if index == -1 {
+		return User{}, ErrNotWaiting
+	}
+
+	if _, err := i.repository.DequeueUser(ctx, id, target, admit); err != nil {
+		return User{}, err
+	}
+
+	return room.Pending[index], nil
+}
+
+// KickUser removes target from the room on behalf of actor, who must be
+// allowed to moderate. Only the owner may kick other moderators. With ban,
+// target's identity cannot join the room again.
+func (i Interactor) KickUser(ctx context.Context, id string, actor uuid.UUID, target uuid.UUID, ban bool) (User, error) {
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	actorUser, ok := room.User(actor)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	targetUser, ok := room.User(target)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	if !actorUser.Role.Can(PermissionModerate) {
+		return User{}, ErrForbidden
+	}
+
+	if targetUser.Role.Privileged() && !actorUser.Role.Can(PermissionManageRoles) {
+		return User{}, ErrForbidden
+	}
+
+	room, err = i.repository.KickUser(ctx, id, target, ban)
+	if err != nil {
+		return User{}, err
+	}
+
+	i.publisher.Publish(NewEvent(EventParticipantsChanged, room))
+
+	return targetUser, nil
+}
+
+// GetRoomUser returns a user as currently stored in the room, so callers
+// see role changes made after the user joined.
+func (i Interactor) GetRoomUser(ctx context.Context, id string, userID uuid.UUID) (User, error) {
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, ok := room.User(userID)
+	if !ok {
+		return User{}, ErrUserNotInRoom
+	}
+
+	return user, nil
+}
+
+func (i Interactor) authorize(ctx context.Context, id string, actor uuid.UUID, permission Permission) error {
+	user, err := i.GetRoomUser(ctx, id, actor)
+	if err != nil {
+		return err
+	}
+
+	if !user.Role.Can(permission) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func (i Interactor) LeaveRoom(ctx context.Context, id string, user User) error {
+	room, err := i.repository.LeaveRoom(ctx, id, user)
+	if err != nil {
+		return err
+	}
+
+	i.publisher.Publish(NewEvent(EventParticipantsChanged, room))
+
+	return nil
+}
+
+func (i Interactor) UpdateRoomMetadata(ctx context.Context, id string, metadata map[string]string) error {
+	room, err := i.repository.SetRoomMetadata(ctx, id, metadata)
+	if err != nil {
+		return err
+	}
+
+	i.publisher.Publish(NewEvent(EventMetadataUpdated, room))
+
+	return nil
+}
+
+func (i Interactor) CloseRoom(ctx context.Context, id string) error {
+	room, err := i.repository.DeleteRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	i.publisher.Publish(NewEvent(EventRoomClosed, room))
+
+	return nil
+}
+
+// CloseEmptyRooms closes every room that has stayed empty for longer than
+// ttl and returns the ids of the closed rooms.
+func (i Interactor) CloseEmptyRooms(ctx context.Context, ttl time.Duration) ([]string, error) {
+	empty, err := i.EmptyRooms(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := make([]string, 0, len(empty))
+	for _, id := range empty {
+		err := i.CloseEmptyRoom(ctx, id, ttl)
+		if errors.Is(err, ErrRoomNotEmpty) || errors.Is(err, ErrRoomNotFound) {
+			continue
+		}
+		if err != nil {
+			return closed, err
+		}
+
+		closed = append(closed, id)
+	}
+
+	return closed, nil
+}
+
+// EmptyRooms returns the ids of the rooms that have stayed empty for longer
+// than ttl. They may fill again before CloseEmptyRoom closes them.
+func (i Interactor) EmptyRooms(ctx context.Context, ttl time.Duration) ([]string, error) {
+	allRooms, err := i.repository.GetRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	emptyBefore := time.Now().Add(-ttl)
+	empty := make([]string, 0)
+
+	for _, room := range allRooms {
+		if len(room.Users) != 0 || room.EmptySince.IsZero() || room.EmptySince.After(emptyBefore) {
+			continue
+		}
+
+		empty = append(empty, room.Id)
+	}
+
+	return empty, nil
+}
+
+// CloseEmptyRoom closes a room only if it is still empty for longer than
+// ttl, failing with ErrRoomNotEmpty otherwise.
+func (i Interactor) CloseEmptyRoom(ctx context.Context, id string, ttl time.Duration) error {
+	deleted, err := i.repository.DeleteEmptyRoom(ctx, id, time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+
+	i.publisher.Publish(NewEvent(EventRoomClosed, deleted))
+
+	return nil
+}
+
+func (i Interactor) GetRoom(ctx context.Context, id string) (Room, error) {
+	room, err := i.repository.GetRoom(ctx, id)
+	return room, err
+}
+
+// ResolveRoom finds a room by id, falling back to its display name for
+// clients that still address rooms by name. Private rooms are only found
+// by id.
+func (i Interactor) ResolveRoom(ctx context.Context, idOrName string) (Room, error) {
+	room, err := i.repository.GetRoom(ctx, idOrName)
+	if !errors.Is(err, ErrRoomNotFound) {
+		return room, err
+	}
+
+	room, err = i.repository.GetRoomByName(ctx, idOrName)
+	if err != nil {
+		return Room{}, err
+	}
+
+	if room.Private {
+		return Room{}, ErrRoomNotFound
+	}
+
+	return room, nil
+}
+
+// GetPublicRooms returns every room that is not private.
+func (i Interactor) GetPublicRooms(ctx context.Context) ([]Room, error) {
+	allRooms, err := i.repository.GetRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	public := make([]Room, 0, len(allRooms))
+	for _, room := range allRooms {
+		if !room.Private {
+			public = append(public, room)
+		}
+	}
+
+	return public, nil
+}
+
+// Authenticate checks the credentials of a user about to join room and
+// returns the user with the role bound by their invite, if any.
+func (i Interactor) Authenticate(room Room, user User, credentials Credentials) (User, error) {
+	user, _, err := i.authenticate(room, user, credentials)
+	return user, err
+}
+
+func (i Interactor) authenticate(room Room, user User, credentials Credentials) (User, *Invite, error) {
+	if credentials.Invite == "" {
+		return user, nil, checkPassword(room, credentials.Password)
+	}
+
+	invite, err := i.invites.Decode(credentials.Invite)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	switch {
+	case invite.RoomID != room.Id:
+		return User{}, nil, ErrInviteInvalid
+	case invite.Username != "" && invite.Username != user.Name:
+		return User{}, nil, ErrInviteInvalid
+	case time.Now().After(invite.ExpiresAt):
+		return User{}, nil, ErrInviteExpired
+	case slices.Contains(room.RevokedInvites, invite.Id):
+		return User{}, nil, ErrInviteRevoked
+	}
+
+	if invite.Role != "" {
+		user.Role = invite.Role
+	}
+
+	return user, &invite, nil
+}
+
+// CreateInvite mints an invite token for a room on behalf of actor, who
+// must be allowed to moderate. Only those who manage roles may hand out
+// privileged roles.
+func (i Interactor) CreateInvite(ctx context.Context, id string, actor uuid.UUID, params InviteParams) (Invite, string, error) {
+	room, err := i.repository.GetRoom(ctx, id)
+	if err != nil {
+		return Invite{}, "", err
+	}
+
+	actorUser, ok := room.User(actor)
+	if !ok {
+		return Invite{}, "", ErrUserNotInRoom
+	}
+
+	if !actorUser.Role.Can(PermissionModerate) {
+		return Invite{}, "", ErrForbidden
+	}
+
+	if params.Role != "" {
+		if !params.Role.Valid() || params.Role == RoleOwner {
+			return Invite{}, "", ErrInvalidRole
+		}
+
+		if params.Role.Privileged() && !actorUser.Role.Can(PermissionManageRoles) {
+			return Invite{}, "", ErrForbidden
+		}
+	}
+
+	ttl := params.TTL
+	if ttl <= 0 {
+		ttl = i.config.DefaultInviteTTL
+	}
+
+	invite := Invite{
+		Id:        uuid.NewString(),
+		RoomID:    room.Id,
+		ExpiresAt: time.Now().Add(ttl),
+		Username:  params.Username,
+		Role:      params.Role,
+		MaxUses:   params.MaxUses,
+	}
+
+	token, err := i.invites.Encode(invite)
+	if err != nil {
+		return Invite{}, "", err
+	}
+
+	return invite, token, nil
+}
+
+// RevokeInvite stops an invite from being used on behalf of actor, who
+// must be allowed to moderate.
+func (i Interactor) RevokeInvite(ctx context.Context, id string, actor uuid.UUID, inviteID string) error {
+	if err := i.authorize(ctx, id, actor, PermissionModerate); err != nil {
+		return err
+	}
+
+	_, err := i.repository.RevokeInvite(ctx, id, inviteID)
 	return err
 }
 
-func (i Interactor) GetRoomUsers(name string) ([]User, error) {
-	users, err := i.repository.GetRoomUsers(name)
+func checkPassword(room Room, password string) error {
+	if !room.HasPassword() {
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(room.PasswordHash, []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+func (i Interactor) GetRoomUsers(ctx context.Context, id string) ([]User, error) {
+	users, err := i.repository.GetRoomUsers(ctx, id)
 	return users, err
 }
 
-func (i Interactor) GetRooms() ([]Room, error) {
-	rooms, err := i.repository.GetRooms()
+func (i Interactor) GetRooms(ctx context.Context) ([]Room, error) {
+	rooms, err := i.repository.GetRooms(ctx)
 	return rooms, err
 }
@@ -0,0 +1,33 @@
+package rooms
+
+import "time"
+
+// Invite lets its holder join a room without the room password. Invites
+// are handed out as signed tokens, so they are never stored; rooms only
+// keep their use counts and revocations.
+type Invite struct {
+	Id        string
+	RoomID    string
+	ExpiresAt time.Time
+	// Username binds the invite to a single user, empty means anyone.
+	Username string
+	// Role, if set, is granted to the user joining with the invite.
+	Role Role
+	// MaxUses limits how many joins the invite allows, zero means unlimited.
+	MaxUses int
+}
+
+// InviteCodec turns invites into tokens and back. Decode must reject
+// tokens it did not sign with ErrInviteInvalid.
+type InviteCodec interface {
+	Encode(invite Invite) (string, error)
+	Decode(token string) (Invite, error)
+}
+
+type InviteParams struct {
+	Username string
+	Role     Role
+	MaxUses  int
+	// TTL left zero is filled from Config.
+	TTL time.Duration
+}
@@ -1,9 +1,49 @@
 package rooms
 
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Repository stores rooms and their members. Rooms are addressed by their
+// immutable id; display names are unique among stored rooms.
+// Implementations must honour ctx cancellation and deadlines and return
+// ctx.Err() when aborted. Mutating methods return the room as it is after
+// the change.
 type Repository interface {
-	CreateRoom(name string) error
-	JoinRoom(name string, user User) error
-	LeaveRoom(name string, user User) error
-	GetRoomUsers(name string) ([]User, error)
-	GetRooms() ([]Room, error)
+	// CreateRoom returns ErrRoomExists if the name is taken, unless the
+	// existing room was created with the same idempotency key, in which
+	// case it is returned instead.
+	CreateRoom(ctx context.Context, room Room) (Room, error)
+	RenameRoom(ctx context.Context, id string, name string) (Room, error)
+	// JoinRoom admits a user with Room.Admit atomically, so concurrent
+	// joins cannot exceed the room capacity.
+	JoinRoom(ctx context.Context, id string, user User) (Room, error)
+	// SetRoomLocked locks or unlocks the room with the same id.
+	SetRoomLocked(ctx context.Context, id string, locked bool) (Room, error)
+	// SetUserRole changes a user's role with Room.SetRole atomically.
+	SetUserRole(ctx context.Context, id string, userID uuid.UUID, role Role) (Room, error)
+	// KickUser removes a user with Room.Kick atomically.
+	KickUser(ctx context.Context, id string, userID uuid.UUID, ban bool) (Room, error)
+	// EnqueueUser puts a user into the lobby with Room.Enqueue atomically.
+	EnqueueUser(ctx context.Context, id string, user User) (Room, error)
+	// DequeueUser takes a user out of the lobby with Room.Dequeue atomically.
+	DequeueUser(ctx context.Context, id string, userID uuid.UUID, admit bool) (Room, error)
+	// JoinRoomWithInvite adds a user with Room.AdmitInvited atomically.
+	JoinRoomWithInvite(ctx context.Context, id string, user User, invite Invite) (Room, error)
+	// RevokeInvite adds an invite id to the room's revocation list.
+	RevokeInvite(ctx context.Context, id string, inviteID string) (Room, error)
+	// LeaveRoom removes the user with the same id.
+	LeaveRoom(ctx context.Context, id string, user User) (Room, error)
+	SetRoomMetadata(ctx context.Context, id string, metadata map[string]string) (Room, error)
+	DeleteRoom(ctx context.Context, id string) (Room, error)
+	// DeleteEmptyRoom deletes a room only if it has been empty since before
+	// emptyBefore, returning ErrRoomNotEmpty otherwise.
+	DeleteEmptyRoom(ctx context.Context, id string, emptyBefore time.Time) (Room, error)
+	GetRoom(ctx context.Context, id string) (Room, error)
+	GetRoomByName(ctx context.Context, name string) (Room, error)
+	GetRoomUsers(ctx context.Context, id string) ([]User, error)
+	GetRooms(ctx context.Context) ([]Room, error)
 }
@@ -0,0 +1,44 @@
+package rooms
+
+type Permission string
+
+const (
+	PermissionChat Permission = "chat"
+	// PermissionPublish allows sending media offers. Everyone may answer
+	// offers, so viewers can still receive media.
+	PermissionPublish     Permission = "publish"
+	PermissionModerate    Permission = "moderate"
+	PermissionManageRoles Permission = "manage_roles"
+)
+
+const (
+	RoleOwner     Role = "owner"
+	RoleModerator Role = "moderator"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:       {PermissionChat, PermissionPublish, PermissionModerate, PermissionManageRoles},
+	RoleModerator:   {PermissionChat, PermissionPublish, PermissionModerate},
+	RoleParticipant: {PermissionChat, PermissionPublish},
+	RoleViewer:      {},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Privileged roles are not limited by the room capacity.
+func (r Role) Privileged() bool {
+	return r.Can(PermissionModerate)
+}
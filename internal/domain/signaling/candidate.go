@@ -0,0 +1,185 @@
+package signaling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type CandidateType string
+
+const (
+	CandidateHost  CandidateType = "host"
+	CandidateSrflx CandidateType = "srflx"
+	CandidatePrflx CandidateType = "prflx"
+	CandidateRelay CandidateType = "relay"
+)
+
+func (t CandidateType) Valid() bool {
+	switch t {
+	case CandidateHost, CandidateSrflx, CandidatePrflx, CandidateRelay:
+		return true
+	default:
+		return false
+	}
+}
+
+// Candidate is an ICE candidate attribute as defined by RFC 8839, e.g.
+// "candidate:1 1 udp 2122260223 192.168.1.2 54321 typ host".
+type Candidate struct {
+	Foundation string
+	Component  int
+	Protocol   string
+	Priority   uint32
+	Address    string
+	Port       int
+	Type       CandidateType
+	// RelatedAddress and RelatedPort are the raddr and rport extensions,
+	// empty for host candidates.
+	RelatedAddress string
+	RelatedPort    int
+}
+
+// ParseCandidate parses a candidate attribute, with or without its "a="
+// and "candidate:" prefixes. Unknown extensions are ignored.
+func ParseCandidate(line string) (Candidate, error) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "a=")
+	line = strings.TrimPrefix(line, "candidate:")
+
+	fields := strings.Fields(line)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return Candidate{}, fmt.Errorf("%w: expected \"<foundation> <component> <protocol> <priority> <address> <port> typ <type>\"", ErrInvalidCandidate)
+	}
+
+	c := Candidate{
+		Foundation: fields[0],
+		Protocol:   strings.ToLower(fields[2]),
+		Address:    fields[4],
+		Type:       CandidateType(fields[7]),
+	}
+
+	component, err := strconv.Atoi(fields[1])
+	if err != nil || component < 1 || component > 256 {
+		return Candidate{}, fmt.Errorf("%w: component must be between 1 and 256", ErrInvalidCandidate)
+	}
+	c.Component = component
+
+	if c.Protocol != "udp" && c.Protocol != "tcp" {
+		return Candidate{}, fmt.Errorf("%w: unknown transport %q", ErrInvalidCandidate, fields[2])
+	}
+
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return Candidate{}, fmt.Errorf("%w: priority must be a 32 bit unsigned integer", ErrInvalidCandidate)
+	}
+	c.Priority = uint32(priority)
+
+	if c.Port, err = parsePort(fields[5]); err != nil {
+		return Candidate{}, err
+	}
+
+	if !c.Type.Valid() {
+		return Candidate{}, fmt.Errorf("%w: unknown candidate type %q", ErrInvalidCandidate, fields[7])
+	}
+
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			c.RelatedAddress = fields[i+1]
+		case "rport":
+			if c.RelatedPort, err = parsePort(fields[i+1]); err != nil {
+				return Candidate{}, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("%w: invalid port %q", ErrInvalidCandidate, s)
+	}
+
+	return port, nil
+}
+
+// CandidatePolicy decides which ICE candidates, and so which addresses of
+// a participant, other peers get to see.
+type CandidatePolicy string
+
+const (
+	CandidatesAll CandidatePolicy = "all"
+	// CandidatesNoHost hides local network addresses.
+	CandidatesNoHost CandidatePolicy = "no-host"
+	// CandidatesRelay only lets TURN relay candidates through, which hides
+	// public addresses as well and forces media through TURN.
+	CandidatesRelay CandidatePolicy = "relay"
+)
+
+var candidatePolicyStrictness = map[CandidatePolicy]int{
+	"":               0,
+	CandidatesAll:    0,
+	CandidatesNoHost: 1,
+	CandidatesRelay:  2,
+}
+
+func (p CandidatePolicy) Valid() bool {
+	_, ok := candidatePolicyStrictness[p]
+	return ok && p != ""
+}
+
+// Stricter returns whichever of p and other hides more.
+func (p CandidatePolicy) Stricter(other CandidatePolicy) CandidatePolicy {
+	if candidatePolicyStrictness[other] > candidatePolicyStrictness[p] {
+		return other
+	}
+
+	return p
+}
+
+func (p CandidatePolicy) filters() bool {
+	return candidatePolicyStrictness[p] > 0
+}
+
+func (p CandidatePolicy) Allows(t CandidateType) bool {
+	switch p {
+	case CandidatesNoHost:
+		return t != CandidateHost
+	case CandidatesRelay:
+		return t == CandidateRelay
+	default:
+		return true
+	}
+}
+
+// FilterCandidate returns line as other peers may see it, and false if
+// they may not see it at all. Filtering policies also hide the related
+// address, which is the participant's own address for relay candidates.
+func (p CandidatePolicy) FilterCandidate(line string) (string, bool, error) {
+	c, err := ParseCandidate(line)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !p.Allows(c.Type) {
+		return "", false, nil
+	}
+
+	if !p.filters() {
+		return line, true, nil
+	}
+
+	fields := strings.Fields(line)
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			fields[i+1] = "0.0.0.0"
+		case "rport":
+			fields[i+1] = "0"
+		}
+	}
+
+	return strings.Join(fields, " "), true, nil
+}
@@ -0,0 +1,13 @@
+package signaling
+
+import "errors"
+
+var (
+	ErrInvalidCandidate    = errors.New("invalid ice candidate")
+	ErrCandidateBufferFull = errors.New("too many ice candidates waiting for their target")
+	ErrUnknownTarget       = errors.New("no such recipient in room")
+
+	ErrInvalidSDP           = errors.New("invalid sdp")
+	ErrNoAllowedCodec       = errors.New("none of the offered codecs is allowed")
+	ErrTooManyMediaSections = errors.New("too many media sections")
+)
@@ -0,0 +1,315 @@
+package signaling
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// staticPayloadTypes names the RTP payload types that need no rtpmap
+// (RFC 3551).
+var staticPayloadTypes = map[string]string{
+	"0":  "pcmu",
+	"3":  "gsm",
+	"8":  "pcma",
+	"9":  "g722",
+	"13": "cn",
+	"18": "g729",
+}
+
+// Policy is what relayed session descriptions must comply with. The zero
+// Policy lets everything through.
+type Policy struct {
+	// Codecs are the allowed codec names, e.g. "opus" or "VP8", in order of
+	// preference. Empty allows every codec in the order it was offered.
+	// Retransmission formats need "rtx" to be allowed.
+	Codecs []string
+	// MaxBitrate caps b=AS and b=TIAS, in kbps. Media sections without
+	// either get b=AS added.
+	MaxBitrate int
+	// DisallowedExtensions are RTP header extension URIs to strip.
+	DisallowedExtensions []string
+	// MaxMediaSections limits the number of m= lines, zero means unlimited.
+	MaxMediaSections int
+	// Candidates filters the ICE candidates of the description.
+	Candidates CandidatePolicy
+}
+
+// Enforce validates an SDP of the given type and rewrites it to comply
+// with the policy.
+func (p Policy) Enforce(sdpType, sdp string) (string, error) {
+	switch sdpType {
+	case SDPOffer, SDPAnswer, SDPPranswer:
+	case SDPRollback:
+		return sdp, nil
+	default:
+		return "", fmt.Errorf("%w: unknown type %q", ErrInvalidSDP, sdpType)
+	}
+
+	desc, err := ParseSDP(sdp)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.Apply(&desc); err != nil {
+		return "", err
+	}
+
+	return desc.String(), nil
+}
+
+func (p Policy) Apply(desc *SessionDescription) error {
+	if p.MaxMediaSections > 0 && len(desc.Media) > p.MaxMediaSections {
+		return fmt.Errorf("%w: %d m-lines, at most %d allowed", ErrTooManyMediaSections, len(desc.Media), p.MaxMediaSections)
+	}
+
+	desc.Session = p.stripExtensions(desc.Session)
+	desc.Session = p.capBitrate(desc.Session)
+
+	for i := range desc.Media {
+		m := &desc.Media[i]
+
+		m.Lines = p.stripExtensions(m.Lines)
+
+		if !m.RTP() || m.Disabled() {
+			continue
+		}
+
+		if err := p.filterCodecs(m); err != nil {
+			return fmt.Errorf("%w: m-line %d (%s)", err, i+1, m.Kind)
+		}
+
+		m.Lines = p.capBitrate(m.Lines)
+		if p.MaxBitrate > 0 && !hasBitrate(m.Lines) {
+			m.Lines = insertBitrate(m.Lines, p.MaxBitrate)
+		}
+	}
+
+	return filterCandidates(desc, p.Candidates)
+}
+
+func (p Policy) stripExtensions(lines []Line) []Line {
+	if len(p.DisallowedExtensions) == 0 {
+		return lines
+	}
+
+	return slices.DeleteFunc(lines, func(line Line) bool {
+		if line.Type != 'a' {
+			return false
+		}
+
+		name, value := line.attribute()
+		fields := strings.Fields(value)
+		return name == "extmap" && len(fields) >= 2 && slices.Contains(p.DisallowedExtensions, fields[1])
+	})
+}
+
+func (p Policy) capBitrate(lines []Line) []Line {
+	if p.MaxBitrate <= 0 {
+		return lines
+	}
+
+	for i, line := range lines {
+		if line.Type != 'b' {
+			continue
+		}
+
+		modifier, value, _ := strings.Cut(line.Value, ":")
+		limit := 0
+		switch modifier {
+		case "AS":
+			limit = p.MaxBitrate
+		case "TIAS":
+			limit = p.MaxBitrate * 1000
+		default:
+			continue
+		}
+
+		if n, err := strconv.Atoi(value); err != nil || n > limit {
+			lines[i].Value = modifier + ":" + strconv.Itoa(limit)
+		}
+	}
+
+	return lines
+}
+
+func hasBitrate(lines []Line) bool {
+	for _, line := range lines {
+		if line.Type == 'b' && (strings.HasPrefix(line.Value, "AS:") || strings.HasPrefix(line.Value, "TIAS:")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insertBitrate adds b=AS where RFC 8866 wants it, before any k= and a=
+// lines of a media section.
+func insertBitrate(lines []Line, kbps int) []Line {
+	index := slices.IndexFunc(lines, func(line Line) bool { return line.Type == 'k' || line.Type == 'a' })
+	if index == -1 {
+		index = len(lines)
+	}
+
+	return slices.Insert(lines, index, Line{Type: 'b', Value: "AS:" + strconv.Itoa(kbps)})
+}
+
+// filterCodecs drops the payload types of disallowed codecs, along with
+// their attributes and retransmission formats, and orders the rest by
+// preference.
+func (p Policy) filterCodecs(m *MediaSection) error {
+	if len(p.Codecs) == 0 {
+		return nil
+	}
+
+	names := map[string]string{}
+	apt := map[string]string{}
+	for _, line := range m.Lines {
+		if line.Type != 'a' {
+			continue
+		}
+
+		name, value := line.attribute()
+		pt, params, _ := strings.Cut(value, " ")
+		switch name {
+		case "rtpmap":
+			codec, _, _ := strings.Cut(params, "/")
+			names[pt] = strings.ToLower(codec)
+		case "fmtp":
+			for _, param := range strings.Split(params, ";") {
+				if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key == "apt" {
+					apt[pt] = value
+				}
+			}
+		}
+	}
+
+	rank := func(pt string) int {
+		name, ok := names[pt]
+		if !ok {
+			name = staticPayloadTypes[pt]
+		}
+
+		return slices.IndexFunc(p.Codecs, func(codec string) bool { return strings.EqualFold(codec, name) })
+	}
+
+	formats := slices.DeleteFunc(slices.Clone(m.Formats), func(pt string) bool { return rank(pt) == -1 })
+	// Retransmission of a dropped codec goes as well.
+	formats = slices.DeleteFunc(formats, func(pt string) bool {
+		target, ok := apt[pt]
+		return ok && !slices.Contains(formats, target)
+	})
+
+	if len(formats) == 0 || !slices.ContainsFunc(formats, func(pt string) bool { _, ok := apt[pt]; return !ok }) {
+		return ErrNoAllowedCodec
+	}
+
+	slices.SortStableFunc(formats, func(a, b string) int { return rank(a) - rank(b) })
+
+	m.Lines = slices.DeleteFunc(m.Lines, func(line Line) bool {
+		if line.Type != 'a' {
+			return false
+		}
+
+		name, value := line.attribute()
+		pt, _, _ := strings.Cut(value, " ")
+		switch name {
+		case "rtpmap", "fmtp", "rtcp-fb":
+			return pt != "*" && !slices.Contains(formats, pt)
+		default:
+			return false
+		}
+	})
+	m.Formats = formats
+
+	return nil
+}
+
+// filterCandidates applies a candidate policy to a description. Besides
+// candidates, connection and origin addresses and a=rtcp may carry the
+// participant's address, so they are masked the way trickle ICE offers do.
+func filterCandidates(desc *SessionDescription, policy CandidatePolicy) error {
+	if !policy.filters() {
+		return nil
+	}
+
+	var err error
+	desc.Session, err = filterCandidateLines(desc.Session, policy)
+	if err != nil {
+		return err
+	}
+
+	for i := range desc.Media {
+		m := &desc.Media[i]
+
+		m.Lines, err = filterCandidateLines(m.Lines, policy)
+		if err != nil {
+			return fmt.Errorf("%w (m-line %d)", err, i+1)
+		}
+
+		if !m.Disabled() {
+			m.Port = "9"
+		}
+	}
+
+	return nil
+}
+
+func filterCandidateLines(lines []Line, policy CandidatePolicy) ([]Line, error) {
+	filtered := lines[:0]
+
+	for _, line := range lines {
+		switch line.Type {
+		case 'a':
+			name, _ := line.attribute()
+			switch name {
+			case "candidate":
+				value, keep, err := policy.FilterCandidate(line.Value)
+				if err != nil {
+					return nil, err
+				}
+				if !keep {
+					continue
+				}
+				line.Value = value
+
+			case "rtcp":
+				continue
+			}
+
+		case 'c':
+			line.Value = maskAddress(line.Value, 2)
+
+		case 'o':
+			line.Value = maskAddress(line.Value, 5)
+		}
+
+		filtered = append(filtered, line)
+	}
+
+	return filtered, nil
+}
+
+// maskAddress replaces the address following the address type at index of
+// value with the unspecified address, or loopback for o= lines.
+func maskAddress(value string, index int) string {
+	fields := strings.Fields(value)
+	if len(fields) <= index {
+		return value
+	}
+
+	ipv6 := fields[index-1] == "IP6"
+	switch {
+	case index == 5 && ipv6:
+		fields[index] = "::1"
+	case index == 5:
+		fields[index] = "127.0.0.1"
+	case ipv6:
+		fields[index] = "::"
+	default:
+		fields[index] = "0.0.0.0"
+	}
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,150 @@
+package signaling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SDP types as used by RTCSessionDescription.
+const (
+	SDPOffer    = "offer"
+	SDPAnswer   = "answer"
+	SDPPranswer = "pranswer"
+	SDPRollback = "rollback"
+)
+
+// Line is a single "<type>=<value>" line of a session description.
+type Line struct {
+	Type  byte
+	Value string
+}
+
+// MediaSection is an m= line and the lines following it up to the next one.
+type MediaSection struct {
+	Kind string
+	// Port is the port of the m= line, possibly followed by "/<count>".
+	Port    string
+	Proto   string
+	Formats []string
+	Lines   []Line
+}
+
+// RTP reports whether the formats of the section are RTP payload types.
+func (m MediaSection) RTP() bool {
+	return strings.Contains(m.Proto, "RTP")
+}
+
+// Disabled reports whether the section was rejected with a zero port.
+func (m MediaSection) Disabled() bool {
+	return m.Port == "0" || strings.HasPrefix(m.Port, "0/")
+}
+
+// SessionDescription is a parsed SDP as defined by RFC 8866. Lines keep
+// their order, so an unchanged description is written back the same, with
+// CRLF line endings.
+type SessionDescription struct {
+	Session []Line
+	Media   []MediaSection
+}
+
+// ParseSDP parses and validates the structure of a session description.
+func ParseSDP(sdp string) (SessionDescription, error) {
+	desc := SessionDescription{}
+
+	raw := strings.Split(strings.TrimRight(sdp, "\r\n"), "\n")
+	for i, text := range raw {
+		text = strings.TrimSuffix(text, "\r")
+		lineNo := i + 1
+
+		if len(text) < 2 || text[1] != '=' || text[0] < 'a' || text[0] > 'z' {
+			return SessionDescription{}, fmt.Errorf("%w: line %d is not a <type>=<value> line", ErrInvalidSDP, lineNo)
+		}
+		line := Line{Type: text[0], Value: text[2:]}
+
+		if i == 0 && (line.Type != 'v' || line.Value != "0") {
+			return SessionDescription{}, fmt.Errorf("%w: must start with v=0", ErrInvalidSDP)
+		}
+
+		if line.Type == 'm' {
+			media, err := parseMediaLine(line.Value)
+			if err != nil {
+				return SessionDescription{}, fmt.Errorf("%w: line %d: %v", ErrInvalidSDP, lineNo, err)
+			}
+			desc.Media = append(desc.Media, media)
+			continue
+		}
+
+		if len(desc.Media) == 0 {
+			desc.Session = append(desc.Session, line)
+		} else {
+			last := &desc.Media[len(desc.Media)-1]
+			last.Lines = append(last.Lines, line)
+		}
+	}
+
+	for _, required := range []byte{'o', 's', 't'} {
+		if !hasLine(desc.Session, required) {
+			return SessionDescription{}, fmt.Errorf("%w: missing %c= line", ErrInvalidSDP, required)
+		}
+	}
+
+	for _, line := range desc.Session {
+		if line.Type == 'o' && len(strings.Fields(line.Value)) != 6 {
+			return SessionDescription{}, fmt.Errorf("%w: o= line must have 6 fields", ErrInvalidSDP)
+		}
+	}
+
+	return desc, nil
+}
+
+func parseMediaLine(value string) (MediaSection, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return MediaSection{}, fmt.Errorf("m= line must be \"<media> <port> <proto> <fmt> ...\"")
+	}
+
+	port, _, _ := strings.Cut(fields[1], "/")
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return MediaSection{}, fmt.Errorf("invalid port %q", fields[1])
+	}
+
+	return MediaSection{Kind: fields[0], Port: fields[1], Proto: fields[2], Formats: fields[3:]}, nil
+}
+
+func hasLine(lines []Line, lineType byte) bool {
+	for _, line := range lines {
+		if line.Type == lineType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d SessionDescription) String() string {
+	b := strings.Builder{}
+
+	writeLines(&b, d.Session)
+	for _, m := range d.Media {
+		writeLines(&b, []Line{{Type: 'm', Value: strings.Join(append([]string{m.Kind, m.Port, m.Proto}, m.Formats...), " ")}})
+		writeLines(&b, m.Lines)
+	}
+
+	return b.String()
+}
+
+func writeLines(b *strings.Builder, lines []Line) {
+	for _, line := range lines {
+		b.WriteByte(line.Type)
+		b.WriteByte('=')
+		b.WriteString(line.Value)
+		b.WriteString("\r\n")
+	}
+}
+
+// attribute splits an a= line into its name and value.
+func (l Line) attribute() (string, string) {
+	name, value, _ := strings.Cut(l.Value, ":")
+	return name, value
+}
@@ -0,0 +1,16 @@
+package signaling
+
+import "time"
+
+// TURNCredentials are a time limited username and password for the TURN
+// servers.
+type TURNCredentials struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// TURNIssuer mints TURN credentials for a user.
+type TURNIssuer interface {
+	Issue(user string) TURNCredentials
+}
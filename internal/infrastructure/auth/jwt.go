@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoKeys       = errors.New("no jwt keys configured")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+const (
+	// DefaultUsernameClaim is used when Options.UsernameClaim is empty.
+	DefaultUsernameClaim = "preferred_username"
+	// DefaultGroupsClaim is used when Options.GroupsClaim is empty.
+	DefaultGroupsClaim = "groups"
+)
+
+type Options struct {
+	// HMACSecret verifies HS256 tokens.
+	HMACSecret []byte
+	// JWKSFile is a local JWKS with the RS256 and ES256 public keys.
+	JWKSFile string
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+	// UsernameClaim names the claim with the display name, falling back to
+	// the subject.
+	UsernameClaim string
+	// GroupsClaim names the claim with the groups used by authorization
+	// policies, a list of strings.
+	GroupsClaim string
+}
+
+// Identity is who a verified token was issued to.
+type Identity struct {
+	Subject  string
+	Username string
+	Groups   []string
+}
+
+type Verifier struct {
+	hmacSecret    []byte
+	keys          map[string]crypto.PublicKey
+	parser        *jwt.Parser
+	usernameClaim string
+	groupsClaim   string
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	v := &Verifier{
+		hmacSecret:    opts.HMACSecret,
+		usernameClaim: opts.UsernameClaim,
+		groupsClaim:   opts.GroupsClaim,
+	}
+
+	if v.usernameClaim == "" {
+		v.usernameClaim = DefaultUsernameClaim
+	}
+	if v.groupsClaim == "" {
+		v.groupsClaim = DefaultGroupsClaim
+	}
+
+	if opts.JWKSFile != "" {
+		keys, err := loadJWKS(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+
+	methods := []string{}
+	if len(v.hmacSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if len(v.keys) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	parserOptions := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if opts.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(opts.Audience))
+	}
+	v.parser = jwt.NewParser(parserOptions...)
+
+	return v, nil
+}
+
+// Verify checks a raw token and returns the identity it was issued to.
+func (v *Verifier) Verify(raw string) (Identity, error) {
+	claims := jwt.MapClaims{}
+
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.key); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	username, _ := claims[v.usernameClaim].(string)
+	if username == "" {
+		username = subject
+	}
+
+	groups := []string{}
+	if values, ok := claims[v.groupsClaim].([]any); ok {
+		for _, value := range values {
+			if group, ok := value.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	return Identity{Subject: subject, Username: username, Groups: groups}, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.hmacSecret, nil
+
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		kid, _ := token.Header["kid"].(string)
+
+		key, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+
+		return nil, fmt.Errorf("key %q does not match %s", kid, token.Method.Alg())
+
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// Policy is the declarative form of an authorizer. Rules are checked in
+// order and the first matching one decides, Default decides otherwise.
+// A policy without a default denies, so a forgotten line fails closed.
+type Policy struct {
+	Default Effect `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule matches a request when every non-empty field matches. Rooms are
+// path.Match patterns checked against both the room id and its name.
+type Rule struct {
+	Effect  Effect         `yaml:"effect"`
+	Actions []rooms.Action `yaml:"actions"`
+	Rooms   []string       `yaml:"rooms"`
+	Users   []string       `yaml:"users"`
+	Groups  []string       `yaml:"groups"`
+}
+
+func (p Policy) validate() error {
+	if p.Default != EffectAllow && p.Default != EffectDeny {
+		return fmt.Errorf("invalid default effect %q", p.Default)
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return fmt.Errorf("rule %d: invalid effect %q", i, rule.Effect)
+		}
+
+		for _, pattern := range rule.Rooms {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid room pattern %q", i, pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p Policy) decide(request rooms.AccessRequest) Effect {
+	for _, rule := range p.Rules {
+		if rule.matches(request) {
+			return rule.Effect
+		}
+	}
+
+	return p.Default
+}
+
+func (r Rule) matches(request rooms.AccessRequest) bool {
+	if len(r.Actions) > 0 && !slices.Contains(r.Actions, request.Action) {
+		return false
+	}
+
+	if len(r.Rooms) > 0 && !slices.ContainsFunc(r.Rooms, func(pattern string) bool {
+		return matchRoom(pattern, request.RoomID) || matchRoom(pattern, request.RoomName)
+	}) {
+		return false
+	}
+
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+
+	principal := request.Principal
+	if slices.Contains(r.Users, "*") || slices.Contains(r.Users, principal.Identity) {
+		return true
+	}
+
+	return slices.ContainsFunc(r.Groups, func(group string) bool {
+		return slices.Contains(principal.Groups, group)
+	})
+}
+
+func matchRoom(pattern, room string) bool {
+	if room == "" {
+		return false
+	}
+
+	ok, _ := path.Match(pattern, room)
+	return ok
+}
+
+// PolicyAuthorizer is a rooms.Authorizer backed by a YAML policy file,
+// reloaded by Watch whenever the file changes.
+type PolicyAuthorizer struct {
+	path    string
+	logger  logger.Logger
+	policy  atomic.Pointer[Policy]
+	modTime time.Time
+}
+
+func NewPolicyAuthorizer(path string, logger logger.Logger) (*PolicyAuthorizer, error) {
+	a := &PolicyAuthorizer{path: path, logger: logger}
+
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *PolicyAuthorizer) Authorize(_ context.Context, request rooms.AccessRequest) error {
+	if a.policy.Load().decide(request) == EffectDeny {
+		return rooms.ErrForbidden
+	}
+
+	return nil
+}
+
+// Watch reloads the policy every interval if the file changed, until ctx
+// is done. An invalid policy is logged and the previous one kept.
+func (a *PolicyAuthorizer) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := a.reload()
+			if err != nil {
+				a.logger.Error(ctx, "couldnt reload authorization policy", zap.String("path", a.path), zap.Error(err))
+			} else if reloaded {
+				a.logger.Info(ctx, "reloaded authorization policy", zap.String("path", a.path))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *PolicyAuthorizer) reload() (bool, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+
+	if info.ModTime().Equal(a.modTime) {
+		return false, nil
+	}
+
+	raw, err := os.ReadFile(a.path)
+	if err != nil {
+		return false, err
+	}
+	// Invalid policies are reported once, not on every check.
+	a.modTime = info.ModTime()
+
+	policy := Policy{Default: EffectDeny}
+	if err := yaml.Unmarshal(raw, &policy); err != nil {
+		return false, fmt.Errorf("parse policy: %w", err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return false, err
+	}
+
+	a.policy.Store(&policy)
+
+	return true, nil
+}
+
+// AllowAll is the rooms.Authorizer used without a policy file.
+type AllowAll struct{}
+
+func (AllowAll) Authorize(context.Context, rooms.AccessRequest) error {
+	return nil
+}
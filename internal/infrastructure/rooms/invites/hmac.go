@@ -0,0 +1,86 @@
+package invites
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+)
+
+// HMAC encodes invites as "<payload>.<signature>", both base64url, where
+// the payload is JSON and the signature is its HMAC-SHA256.
+type HMAC struct {
+	secret []byte
+}
+
+func NewHMAC(secret []byte) *HMAC {
+	return &HMAC{secret: secret}
+}
+
+type claims struct {
+	Id        string `json:"jti"`
+	RoomID    string `json:"room"`
+	ExpiresAt int64  `json:"exp"`
+	Username  string `json:"username,omitempty"`
+	Role      string `json:"role,omitempty"`
+	MaxUses   int    `json:"max_uses,omitempty"`
+}
+
+func (c *HMAC) Encode(invite rooms.Invite) (string, error) {
+	payload, err := json.Marshal(claims{
+		Id:        invite.Id,
+		RoomID:    invite.RoomID,
+		ExpiresAt: invite.ExpiresAt.Unix(),
+		Username:  invite.Username,
+		Role:      string(invite.Role),
+		MaxUses:   invite.MaxUses,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded)), nil
+}
+
+func (c *HMAC) Decode(token string) (rooms.Invite, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return rooms.Invite{}, rooms.ErrInviteInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, c.sign(encoded)) {
+		return rooms.Invite{}, rooms.ErrInviteInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return rooms.Invite{}, rooms.ErrInviteInvalid
+	}
+
+	var cl claims
+	if err := json.Unmarshal(payload, &cl); err != nil {
+		return rooms.Invite{}, rooms.ErrInviteInvalid
+	}
+
+	return rooms.Invite{
+		Id:        cl.Id,
+		RoomID:    cl.RoomID,
+		ExpiresAt: time.Unix(cl.ExpiresAt, 0),
+		Username:  cl.Username,
+		Role:      rooms.Role(cl.Role),
+		MaxUses:   cl.MaxUses,
+	}, nil
+}
+
+func (c *HMAC) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -1,85 +1,353 @@
 package memory
 
 import (
-	"fmt"
+	"context"
+	"hash/fnv"
+	"maps"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/google/uuid"
 )
 
-type Repository struct {
+const DefaultShards = 32
+
+type shard struct {
+	mu    sync.RWMutex
 	rooms map[string]rooms.Room
 }
 
+// Repository keeps rooms in memory, sharded by room id. Every result is a
+// copy, so callers may keep or modify it without racing with later writes.
+//
+// Display names are unique, which is enforced by a separate name index.
+// Operations that touch both take namesMu before the shard lock.
+type Repository struct {
+	shards []*shard
+
+	namesMu sync.RWMutex
+	names   map[string]string
+}
+
 func NewRepository() *Repository {
-	return &Repository{
-		rooms: make(map[string]rooms.Room),
+	return NewShardedRepository(DefaultShards)
+}
+
+func NewShardedRepository(shards int) *Repository {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+
+	r := &Repository{
+		shards: make([]*shard, shards),
+		names:  make(map[string]string),
 	}
+	for i := range r.shards {
+		r.shards[i] = &shard{rooms: make(map[string]rooms.Room)}
+	}
+
+	return r
 }
 
-func (r *Repository) CreateRoom(name string) error {
-	room := rooms.Room{Name: name, Users: make([]rooms.User, 0)}
-	r.rooms[room.Name] = room
+func (r *Repository) shard(id string) *shard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(id))
 
-	return nil
+	return r.shards[hash.Sum32()%uint32(len(r.shards))]
 }
 
-func (r *Repository) JoinRoom(name string, user rooms.User) error {
-	room, ok := r.rooms[name]
+// update applies fn to a stored room under the shard lock and returns a copy
+// of the result. The room passed to fn is already a copy, so a failing fn
+// leaves the stored room untouched.
+func (r *Repository) update(ctx context.Context, id string, fn func(room *rooms.Room) error) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
+	}
+
+	s := r.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.rooms[id]
 	if !ok {
-		return fmt.Errorf("no such room with given name")
+		return rooms.Room{}, rooms.ErrRoomNotFound
+	}
+
+	room := clone(stored)
+	if err := fn(&room); err != nil {
+		return rooms.Room{}, err
 	}
 
-	room.Users = append(room.Users, user)
-	r.rooms[name] = room
+	s.rooms[id] = room
 
-	return nil
+	return clone(room), nil
 }
 
-func (r *Repository) LeaveRoom(id string, user rooms.User) error {
-	room, ok := r.rooms[id]
-	if !ok {
-		return fmt.Errorf("no such room with given id")
+func clone(room rooms.Room) rooms.Room {
+	room.Users = slices.Clone(room.Users)
+	room.Metadata = maps.Clone(room.Metadata)
+	room.PasswordHash = slices.Clone(room.PasswordHash)
+	room.Moderators = slices.Clone(room.Moderators)
+	room.Banned = slices.Clone(room.Banned)
+	room.Pending = slices.Clone(room.Pending)
+	room.Admitted = slices.Clone(room.Admitted)
+	room.InviteUses = maps.Clone(room.InviteUses)
+	room.RevokedInvites = slices.Clone(room.RevokedInvites)
+
+	return room
+}
+
+// CreateRoom stores a new room. If a room with the same name exists and was
+// created with the same non-empty idempotency key, that room is returned
+// instead of ErrRoomExists.
+func (r *Repository) CreateRoom(ctx context.Context, room rooms.Room) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
 	}
 
-	users := room.Users
-	if !slices.Contains(users, user) {
-		return fmt.Errorf("no such user in room")
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
+
+	if id, ok := r.names[room.Name]; ok {
+		existing, err := r.GetRoom(ctx, id)
+		if err != nil {
+			return rooms.Room{}, err
+		}
+
+		if room.IdempotencyKey != "" && existing.IdempotencyKey == room.IdempotencyKey {
+			return existing, nil
+		}
+
+		return rooms.Room{}, rooms.ErrRoomExists
+	}
+
+	room = clone(room)
+	if room.Users == nil {
+		room.Users = make([]rooms.User, 0)
+	}
+	if room.Metadata == nil {
+		room.Metadata = make(map[string]string)
+	}
+	if room.EmptySince.IsZero() && len(room.Users) == 0 {
+		room.EmptySince = time.Now()
+	}
+
+	s := r.shard(room.Id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rooms[room.Id]; ok {
+		return rooms.Room{}, rooms.ErrRoomExists
+	}
+
+	s.rooms[room.Id] = room
+	r.names[room.Name] = room.Id
+
+	return clone(room), nil
+}
+
+func (r *Repository) RenameRoom(ctx context.Context, id string, name string) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
+	}
+
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
+
+	if owner, ok := r.names[name]; ok && owner != id {
+		return rooms.Room{}, rooms.ErrRoomExists
+	}
+
+	var previous string
+	room, err := r.update(ctx, id, func(room *rooms.Room) error {
+		previous = room.Name
+		room.Name = name
+
+		return nil
+	})
+	if err != nil {
+		return rooms.Room{}, err
 	}
 
-	index := 0
+	delete(r.names, previous)
+	r.names[name] = id
+
+	return room, nil
+}
+
+func (r *Repository) JoinRoom(ctx context.Context, id string, user rooms.User) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		_, err := room.Admit(user)
+		return err
+	})
+}
+
+func (r *Repository) SetRoomLocked(ctx context.Context, id string, locked bool) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		room.Locked = locked
+		return nil
+	})
+}
+
+func (r *Repository) SetUserRole(ctx context.Context, id string, userID uuid.UUID, role rooms.Role) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		_, err := room.SetRole(userID, role)
+		return err
+	})
+}
+
+func (r *Repository) KickUser(ctx context.Context, id string, userID uuid.UUID, ban bool) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		_, err := room.Kick(userID, ban)
+		return err
+	})
+}
+
+func (r *Repository) EnqueueUser(ctx context.Context, id string, user rooms.User) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		return room.Enqueue(user)
+	})
+}
+
+func (r *Repository) DequeueUser(ctx context.Context, id string, userID uuid.UUID, admit bool) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		_, err := room.Dequeue(userID, admit)
+		return err
+	})
+}
+
+func (r *Repository) JoinRoomWithInvite(ctx context.Context, id string, user rooms.User, invite rooms.Invite) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		_, err := room.AdmitInvited(user, invite)
+		return err
+	})
+}
+
+func (r *Repository) RevokeInvite(ctx context.Context, id string, inviteID string) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		room.RevokeInvite(inviteID)
+		return nil
+	})
+}
 
-	for i, v := range users {
-		if v == user {
-			index = i
-			break
+func (r *Repository) LeaveRoom(ctx context.Context, id string, user rooms.User) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		// The users slice is a fresh copy, so removing in place cannot
+		// disturb slices returned by earlier reads.
+		return room.Remove(user.Id)
+	})
+}
+
+func (r *Repository) SetRoomMetadata(ctx context.Context, id string, metadata map[string]string) (rooms.Room, error) {
+	return r.update(ctx, id, func(room *rooms.Room) error {
+		room.Metadata = maps.Clone(metadata)
+
+		return nil
+	})
+}
+
+func (r *Repository) DeleteRoom(ctx context.Context, id string) (rooms.Room, error) {
+	return r.delete(ctx, id, func(rooms.Room) error { return nil })
+}
+
+func (r *Repository) DeleteEmptyRoom(ctx context.Context, id string, emptyBefore time.Time) (rooms.Room, error) {
+	return r.delete(ctx, id, func(room rooms.Room) error {
+		if len(room.Users) != 0 || room.EmptySince.IsZero() || room.EmptySince.After(emptyBefore) {
+			return rooms.ErrRoomNotEmpty
 		}
+
+		return nil
+	})
+}
+
+func (r *Repository) delete(ctx context.Context, id string, check func(room rooms.Room) error) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
 	}
 
-	users[index] = users[len(users)-1]
-	users = users[:len(users)-1]
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
 
-	room.Users = users
-	r.rooms[id] = room
+	s := r.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return nil
+	room, ok := s.rooms[id]
+	if !ok {
+		return rooms.Room{}, rooms.ErrRoomNotFound
+	}
+
+	if err := check(room); err != nil {
+		return rooms.Room{}, err
+	}
+
+	delete(s.rooms, id)
+	delete(r.names, room.Name)
+
+	return clone(room), nil
+}
+
+func (r *Repository) GetRoom(ctx context.Context, id string) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
+	}
+
+	s := r.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	room, ok := s.rooms[id]
+	if !ok {
+		return rooms.Room{}, rooms.ErrRoomNotFound
+	}
+
+	return clone(room), nil
 }
 
-func (r *Repository) GetRoomUsers(id string) ([]rooms.User, error) {
-	room, ok := r.rooms[id]
+func (r *Repository) GetRoomByName(ctx context.Context, name string) (rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return rooms.Room{}, err
+	}
+
+	r.namesMu.RLock()
+	id, ok := r.names[name]
+	r.namesMu.RUnlock()
+
 	if !ok {
-		return nil, fmt.Errorf("no such room with given id")
+		return rooms.Room{}, rooms.ErrRoomNotFound
+	}
+
+	return r.GetRoom(ctx, id)
+}
+
+func (r *Repository) GetRoomUsers(ctx context.Context, id string) ([]rooms.User, error) {
+	room, err := r.GetRoom(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	users := room.Users
-	return users, nil
+	return room.Users, nil
 }
 
-func (r *Repository) GetRooms() ([]rooms.Room, error) {
-	roomsValues := make([]rooms.Room, len(r.rooms))
+func (r *Repository) GetRooms(ctx context.Context) ([]rooms.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	roomsValues := make([]rooms.Room, 0)
 
-	for _, v := range r.rooms {
-		roomsValues = append(roomsValues, v)
+	for _, s := range r.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		s.mu.RLock()
+		for _, v := range s.rooms {
+			roomsValues = append(roomsValues, clone(v))
+		}
+		s.mu.RUnlock()
 	}
 
 	return roomsValues, nil
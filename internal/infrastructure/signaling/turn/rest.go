@@ -0,0 +1,38 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+)
+
+// REST issues credentials for the TURN REST API shared secret scheme, as
+// coturn implements it with use-auth-secret: the username is
+// "<expiry unix time>:<user>" and the password is the base64 encoded
+// HMAC-SHA1 of the username, keyed with the shared secret.
+type REST struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewREST(secret []byte, ttl time.Duration) *REST {
+	return &REST{secret: secret, ttl: ttl}
+}
+
+func (r *REST) Issue(user string) signaling.TURNCredentials {
+	expiresAt := time.Now().Add(r.ttl).Truncate(time.Second)
+	username := strconv.FormatInt(expiresAt.Unix(), 10) + ":" + user
+
+	mac := hmac.New(sha1.New, r.secret)
+	mac.Write([]byte(username))
+
+	return signaling.TURNCredentials{
+		Username:  username,
+		Password:  base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		ExpiresAt: expiresAt,
+	}
+}
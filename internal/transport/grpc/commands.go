@@ -0,0 +1,264 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const commandPrefix = "/"
+
+// command is a room control request sent as a chat message, since the rooms
+// contract has no dedicated methods for them, e.g. "/role <user-id> moderator".
+type command struct {
+	name string
+	args []string
+}
+
+// commands are the names of the known commands, mapped to the action
+// each performs.
+var commands = map[string]rooms.Action{
+	"role":        rooms.ActionModerate,
+	"kick":        rooms.ActionModerate,
+	"ban":         rooms.ActionModerate,
+	"lock":        rooms.ActionModerate,
+	"unlock":      rooms.ActionModerate,
+	"invite":      rooms.ActionModerate,
+	"revoke":      rooms.ActionModerate,
+	"admit":       rooms.ActionModerate,
+	"deny":        rooms.ActionModerate,
+	"replay":      rooms.ActionReplay,
+	"ice-servers": rooms.ActionICEServers,
+}
+
+// leaveCommand leaves the room and ends the stream, without the grace
+// period a stream that merely ended gets.
+const leaveCommand = commandPrefix + "leave"
+
+func isLeave(msg *proto.RoomMethod) bool {
+	return strings.TrimSpace(msg.GetSendMessage().GetText()) == leaveCommand
+}
+
+// parseCommand recognizes known commands only, so that chat messages which
+// merely start with a slash, like "/shrug", are delivered as they are.
+func parseCommand(text string) (command, bool) {
+	if !strings.HasPrefix(text, commandPrefix) {
+		return command{}, false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(text, commandPrefix))
+	if len(fields) == 0 {
+		return command{}, false
+	}
+
+	if _, ok := commands[fields[0]]; !ok {
+		return command{}, false
+	}
+
+	return command{name: fields[0], args: fields[1:]}, true
+}
+
+func (c command) action() rooms.Action {
+	return commands[c.name]
+}
+
+// handleCommand runs a command on behalf of user. Errors are meant to be
+// reported back to the sender, they never end the stream.
+func (s *RoomsService) handleCommand(ctx context.Context, interactor rooms.Interactor, client *hub.Client, roomID string, user rooms.User, cmd command) error {
+	switch cmd.name {
+	case "role":
+		if len(cmd.args) != 2 {
+			return invalidArgument("INVALID_COMMAND", "usage: /role <user-id> <role>")
+		}
+
+		target, err := uuid.Parse(cmd.args[0])
+		if err != nil {
+			return invalidArgument("INVALID_COMMAND", "invalid user id")
+		}
+
+		if _, err := interactor.SetRole(ctx, roomID, user.Id, target, rooms.Role(cmd.args[1])); err != nil {
+			return err
+		}
+
+		return s.sendRoomUsers(ctx, interactor, roomID)
+
+	case "kick", "ban":
+		if len(cmd.args) != 1 {
+			return invalidArgument("INVALID_COMMAND", "usage: /%s <user-id>", cmd.name)
+		}
+
+		target, err := uuid.Parse(cmd.args[0])
+		if err != nil {
+			return invalidArgument("INVALID_COMMAND", "invalid user id")
+		}
+
+		return s.kickUser(ctx, interactor, roomID, user, target, cmd.name == "ban")
+
+	case "lock", "unlock":
+		locked := cmd.name == "lock"
+		if err := interactor.SetRoomLocked(ctx, roomID, user.Id, locked); err != nil {
+			return err
+		}
+
+		event := dispatcherEvent{Type: "room_unlocked", Data: map[string]string{"by": user.Name}}
+		if locked {
+			event.Type = "room_locked"
+		}
+		if err := s.hub.Broadcast(roomID, dispatcherMethod(event)); err != nil {
+			s.logger.Warn(ctx, "couldnt deliver room lock to every room user", zap.String("room_id", roomID), zap.Error(err))
+		}
+
+		return nil
+
+	case "invite":
+		params, err := inviteParams(cmd.args)
+		if err != nil {
+			return err
+		}
+
+		invite, token, err := interactor.CreateInvite(ctx, roomID, user.Id, params)
+		if err != nil {
+			return err
+		}
+
+		return client.Send(dispatcherMethod(dispatcherEvent{
+			Type: "invite",
+			Data: inviteJSON{Id: invite.Id, Token: token, ExpiresAt: invite.ExpiresAt},
+		}))
+
+	case "revoke":
+		if len(cmd.args) != 1 {
+			return invalidArgument("INVALID_COMMAND", "usage: /revoke <invite-id>")
+		}
+
+		return interactor.RevokeInvite(ctx, roomID, user.Id, cmd.args[0])
+
+	case "admit", "deny":
+		if len(cmd.args) != 1 {
+			return invalidArgument("INVALID_COMMAND", "usage: /%s <user-id>", cmd.name)
+		}
+
+		target, err := uuid.Parse(cmd.args[0])
+		if err != nil {
+			return invalidArgument("INVALID_COMMAND", "invalid user id")
+		}
+
+		var decision error
+		if cmd.name == "admit" {
+			_, err = interactor.AdmitUser(ctx, roomID, user.Id, target)
+		} else {
+			_, err = interactor.DenyUser(ctx, roomID, user.Id, target)
+			decision = rooms.ErrJoinDenied
+		}
+		if err != nil {
+			return err
+		}
+
+		s.lobby.resolve(roomID, target, decision)
+
+		return nil
+
+	case "replay":
+		if len(cmd.args) != 1 {
+			return invalidArgument("INVALID_COMMAND", "usage: /replay <seq>")
+		}
+
+		seq, err := strconv.ParseUint(cmd.args[0], 10, 64)
+		if err != nil {
+			return invalidArgument("INVALID_COMMAND", "invalid sequence number")
+		}
+
+		_, err = s.hub.Replay(client, seq)
+		return err
+
+	case "ice-servers":
+		return client.Send(dispatcherMethod(dispatcherEvent{Type: "ice_servers", Data: s.iceServers(user)}))
+
+	default:
+		return invalidArgument("INVALID_COMMAND", "unknown command %q", cmd.name)
+	}
+}
+
+// kickUser removes target from the room, ends its stream and lets everyone
+// else know so they can drop their peer connections to it.
+func (s *RoomsService) kickUser(ctx context.Context, interactor rooms.Interactor, roomID string, actor rooms.User, target uuid.UUID, ban bool) error {
+	kicked, err := interactor.KickUser(ctx, roomID, actor.Id, target, ban)
+	if err != nil {
+		return err
+	}
+
+	reason := rooms.ErrKicked
+	if ban {
+		reason = rooms.ErrBanned
+	}
+
+	if client, ok := s.hub.Client(roomID, kicked.Id); ok {
+		client.Close(reason)
+	}
+
+	event := dispatcherEvent{
+		Type: "user_kicked",
+		Data: kickedUserJSON{Id: kicked.Id.String(), Username: kicked.Name, Banned: ban, By: actor.Name},
+	}
+	if err := s.hub.Broadcast(roomID, dispatcherMethod(event)); err != nil {
+		s.logger.Warn(ctx, "couldnt deliver kick to every room user", zap.String("room_id", roomID), zap.Error(err))
+	}
+
+	return s.sendRoomUsers(ctx, interactor, roomID)
+}
+
+type kickedUserJSON struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Banned   bool   `json:"banned"`
+	By       string `json:"by"`
+}
+
+type inviteJSON struct {
+	Id        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// inviteParams parses "key=value" arguments of /invite, with the keys
+// username, role, uses and ttl.
+func inviteParams(args []string) (rooms.InviteParams, error) {
+	params := rooms.InviteParams{}
+
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return params, invalidArgument("INVALID_COMMAND", "invalid invite argument %q", arg)
+		}
+
+		switch key {
+		case "username":
+			params.Username = value
+		case "role":
+			params.Role = rooms.Role(value)
+		case "uses":
+			uses, err := strconv.Atoi(value)
+			if err != nil || uses < 0 {
+				return params, invalidArgument("INVALID_COMMAND", "invite uses must be a non-negative integer")
+			}
+			params.MaxUses = uses
+		case "ttl":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return params, invalidArgument("INVALID_COMMAND", "invite ttl must be a positive duration")
+			}
+			params.TTL = ttl
+		default:
+			return params, invalidArgument("INVALID_COMMAND", "unknown invite argument %q", key)
+		}
+	}
+
+	return params, nil
+}
@@ -0,0 +1,65 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"google.golang.org/grpc/status"
+)
+
+var errReservedUsername = errors.New("username is reserved for the server")
+
+// reservedUsername reports whether users may not go by username, so that
+// nobody can pass their chat messages off as dispatcher messages.
+func reservedUsername(username string) bool {
+	return strings.EqualFold(strings.TrimSpace(username), dispatcherUsername)
+}
+
+// dispatcherEvent is a server originated notification. The rooms contract
+// has no message for those, so they are sent as chat messages from the
+// reserved dispatcher username with a JSON encoded text.
+type dispatcherEvent struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Data      any    `json:"data,omitempty"`
+}
+
+func dispatcherMethod(event dispatcherEvent) *proto.RoomMethod {
+	text, _ := json.Marshal(event)
+
+	return &proto.RoomMethod{
+		Method: &proto.RoomMethod_MessageReceived{
+			MessageReceived: &proto.MessageReceivedNotification{
+				Text:     string(text),
+				Username: dispatcherUsername,
+			},
+		},
+	}
+}
+
+// sendError reports a failed request back to its sender without tearing
+// down the sender's stream. Errors for single recipients name the recipient
+// in "to".
+func sendError(client *hub.Client, requestID string, err error) error {
+	event := dispatcherEvent{Type: "error", RequestID: requestID}
+
+	var target targetError
+	if errors.As(err, &target) {
+		event.Data = map[string]string{"to": target.target}
+		err = target.err
+	}
+
+	st := status.Convert(toStatus(err))
+	event.Code = st.Code().String()
+	event.Reason = reasonOf(st)
+	event.Message = st.Message()
+
+	return client.Send(dispatcherMethod(event))
+}
@@ -0,0 +1,134 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const errorDomain = "rooms"
+
+type errorMapping struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+var errorMappings = []errorMapping{
+	{rooms.ErrRoomNotFound, codes.NotFound, "ROOM_NOT_FOUND"},
+	{rooms.ErrRoomExists, codes.AlreadyExists, "ROOM_EXISTS"},
+	{rooms.ErrUserNotInRoom, codes.FailedPrecondition, "USER_NOT_IN_ROOM"},
+	{rooms.ErrUsernameTaken, codes.AlreadyExists, "USERNAME_TAKEN"},
+	{rooms.ErrRoomNotEmpty, codes.FailedPrecondition, "ROOM_NOT_EMPTY"},
+	{rooms.ErrRoomClosed, codes.Aborted, "ROOM_CLOSED"},
+	{rooms.ErrRoomFull, codes.ResourceExhausted, "ROOM_FULL"},
+	{rooms.ErrWrongPassword, codes.PermissionDenied, "WRONG_PASSWORD"},
+	{rooms.ErrInvalidRole, codes.InvalidArgument, "INVALID_ROLE"},
+	{rooms.ErrForbidden, codes.PermissionDenied, "FORBIDDEN"},
+	{rooms.ErrKicked, codes.Aborted, "KICKED"},
+	{rooms.ErrBanned, codes.PermissionDenied, "BANNED"},
+	{rooms.ErrRoomLocked, codes.FailedPrecondition, "ROOM_LOCKED"},
+	{rooms.ErrInviteInvalid, codes.PermissionDenied, "INVITE_INVALID"},
+	{rooms.ErrInviteExpired, codes.PermissionDenied, "INVITE_EXPIRED"},
+	{rooms.ErrInviteRevoked, codes.PermissionDenied, "INVITE_REVOKED"},
+	{rooms.ErrInviteExhausted, codes.ResourceExhausted, "INVITE_EXHAUSTED"},
+	{rooms.ErrAdmissionRequired, codes.FailedPrecondition, "ADMISSION_REQUIRED"},
+	{rooms.ErrNotWaiting, codes.NotFound, "NOT_WAITING"},
+	{rooms.ErrJoinDenied, codes.PermissionDenied, "JOIN_DENIED"},
+	{signaling.ErrInvalidCandidate, codes.InvalidArgument, "INVALID_CANDIDATE"},
+	{signaling.ErrCandidateBufferFull, codes.ResourceExhausted, "CANDIDATE_BUFFER_FULL"},
+	{signaling.ErrUnknownTarget, codes.NotFound, "UNKNOWN_TARGET"},
+	{signaling.ErrInvalidSDP, codes.InvalidArgument, "INVALID_SDP"},
+	{signaling.ErrNoAllowedCodec, codes.InvalidArgument, "NO_ALLOWED_CODEC"},
+	{signaling.ErrTooManyMediaSections, codes.InvalidArgument, "TOO_MANY_MEDIA_SECTIONS"},
+	{hub.ErrSlowConsumer, codes.ResourceExhausted, "SLOW_CONSUMER"},
+	{hub.ErrQueueFull, codes.ResourceExhausted, "QUEUE_FULL"},
+	{hub.ErrNotRegistered, codes.Unavailable, "NOT_CONNECTED"},
+	{hub.ErrReplayGap, codes.OutOfRange, "REPLAY_GAP"},
+	{hub.ErrReplayPending, codes.ResourceExhausted, "REPLAY_PENDING"},
+	{hub.ErrReattached, codes.Aborted, "SESSION_RESUMED"},
+	{errSessionNotFound, codes.NotFound, "SESSION_NOT_FOUND"},
+	{errReservedUsername, codes.InvalidArgument, "RESERVED_USERNAME"},
+	{context.Canceled, codes.Canceled, "CANCELED"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+}
+
+// toStatus translates domain errors into grpc statuses carrying a
+// google.rpc.ErrorInfo detail, so clients can branch on the reason instead
+// of the message. Errors that already are statuses are passed through.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// Statuses made elsewhere, like by grpc itself, get a reason naming
+	// their code.
+	if st, ok := status.FromError(err); ok {
+		if st.Code() == codes.OK || reasonOf(st) != "" {
+			return err
+		}
+		return statusWithReason(st.Code(), codeReason(st.Code()), st.Message())
+	}
+
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return statusWithReason(m.code, m.reason, err.Error())
+		}
+	}
+
+	return statusWithReason(codes.Internal, "INTERNAL", err.Error())
+}
+
+func statusWithReason(code codes.Code, reason, message string) error {
+	st := status.New(code, message)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// invalidArgument is the status of malformed input, with reason naming
+// which input was malformed.
+func invalidArgument(reason, format string, args ...any) error {
+	return statusWithReason(codes.InvalidArgument, reason, fmt.Sprintf(format, args...))
+}
+
+// codeReason spells a code the way reasons are spelled, e.g.
+// INVALID_ARGUMENT.
+func codeReason(code codes.Code) string {
+	var reason strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			reason.WriteByte('_')
+		}
+		reason.WriteRune(unicode.ToUpper(r))
+	}
+
+	return reason.String()
+}
+
+// reasonOf returns the ErrorInfo reason attached to a status, if any.
+func reasonOf(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,271 @@
+package grpc
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+)
+
+// Gateway serves the parts of the rooms API that the rooms proto contract
+// has no messages for. Routes live on the grpc-gateway mux, so they share
+// its CORS and websocket proxying and report errors in the same shape.
+type Gateway struct {
+	mux       *runtime.ServeMux
+	service   *RoomsService
+	verifier  *auth.Verifier
+	marshaler runtime.Marshaler
+}
+
+// RegisterGatewayRoutes adds the gateway routes to mux. With a verifier the
+// routes require a JWT, like the RoomsService methods.
+func RegisterGatewayRoutes(mux *runtime.ServeMux, service *RoomsService, verifier *auth.Verifier) error {
+	g := &Gateway{mux: mux, service: service, verifier: verifier, marshaler: &runtime.JSONPb{}}
+
+	routes := []struct {
+		method  string
+		path    string
+		handler runtime.HandlerFunc
+	}{
+		{http.MethodGet, "/rooms/events", g.roomEvents},
+		{http.MethodDelete, "/rooms/{id}", g.closeRoom},
+		{http.MethodPut, "/rooms/{id}/name", g.renameRoom},
+		{http.MethodPut, "/rooms/{id}/metadata", g.updateRoomMetadata},
+		{http.MethodGet, "/rooms/{id}/ice-servers", g.iceServers},
+	}
+
+	for _, route := range routes {
+		if err := mux.HandlePath(route.method, route.path, g.authenticated(route.handler)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *Gateway) authenticated(handler runtime.HandlerFunc) runtime.HandlerFunc {
+	if g.verifier == nil {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		ctx, err := authenticate(r.Context(), g.verifier, r.Header.Get("Authorization"))
+		if err != nil {
+			g.writeError(w, r, err)
+			return
+		}
+
+		handler(w, r.WithContext(ctx), params)
+	}
+}
+
+type roomJSON struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	Participants int               `json:"participants"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+
+	PasswordProtected bool   `json:"password_protected"`
+	Locked            bool   `json:"locked"`
+	CandidatePolicy   string `json:"candidate_policy"`
+}
+
+type roomEventJSON struct {
+	Type  string     `json:"type"`
+	Room  *roomJSON  `json:"room,omitempty"`
+	Rooms []roomJSON `json:"rooms,omitempty"`
+}
+
+func newRoomJSON(event rooms.Event) roomJSON {
+	return roomJSON{
+		Id:           event.RoomID,
+		Name:         event.Room,
+		Participants: event.Participants,
+		Metadata:     event.Metadata,
+
+		PasswordProtected: event.PasswordProtected,
+		Locked:            event.Locked,
+		CandidatePolicy:   string(event.CandidatePolicy),
+	}
+}
+
+// roomEvents streams a snapshot of every room followed by lifecycle deltas
+// as newline delimited JSON, the same framing grpc-gateway uses for
+// server streaming methods.
+func (g *Gateway) roomEvents(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	ctx := r.Context()
+	interactor := g.service.interactor()
+
+	// Subscribe before taking the snapshot so no change falls in between.
+	subscription := g.service.events.Subscribe()
+	defer subscription.Close()
+
+	allRooms, err := interactor.GetPublicRooms(ctx)
+	if err != nil {
+		g.writeError(w, r, toStatus(err))
+		return
+	}
+
+	snapshot := roomEventJSON{Type: "snapshot", Rooms: make([]roomJSON, 0, len(allRooms))}
+	for _, room := range allRooms {
+		snapshot.Rooms = append(snapshot.Rooms, newRoomJSON(rooms.NewEvent(rooms.EventRoomCreated, room)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	send := func(event roomEventJSON) error {
+		if err := encoder.Encode(map[string]roomEventJSON{"result": event}); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	if err := send(snapshot); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case event := <-subscription.C():
+			if event.Private {
+				continue
+			}
+
+			room := newRoomJSON(event)
+			if err := send(roomEventJSON{Type: string(event.Type), Room: &room}); err != nil {
+				g.service.logger.Error(ctx, "could not send room event", zap.String("room_id", event.RoomID), zap.Error(err))
+				return
+			}
+
+		case <-subscription.Done():
+			g.service.logger.Warn(ctx, "room events listener dropped", zap.Error(subscription.Err()))
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *Gateway) updateRoomMetadata(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	ctx := r.Context()
+
+	var metadata map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		g.writeError(w, r, invalidArgument("INVALID_BODY", "metadata must be a JSON object of strings"))
+		return
+	}
+
+	room, err := g.moderatedRoom(r, params["id"])
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	if err := g.service.interactor().UpdateRoomMetadata(ctx, room.Id, metadata); err != nil {
+		g.writeError(w, r, toStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) renameRoom(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	ctx := r.Context()
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		g.writeError(w, r, invalidArgument("INVALID_BODY", "body must be a JSON object with a non-empty name"))
+		return
+	}
+
+	room, err := g.moderatedRoom(r, params["id"])
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	if err := g.service.interactor().RenameRoom(ctx, room.Id, body.Name); err != nil {
+		g.writeError(w, r, toStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) closeRoom(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	room, err := g.moderatedRoom(r, params["id"])
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	if err := g.service.closeRoom(r.Context(), room.Id, g.service.interactor().CloseRoom); err != nil {
+		g.writeError(w, r, toStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// caller is the user making a gateway request, identified by their JWT or
+// by the Username header when authentication is disabled. Its id is not
+// the id of any of the caller's connections.
+func caller(r *http.Request) (rooms.User, error) {
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok {
+		return rooms.User{Name: identity.Username, Subject: identity.Subject}, nil
+	}
+
+	username := r.Header.Get("Username")
+	if username == "" {
+		return rooms.User{}, invalidArgument("INVALID_METADATA", "couldnt extract username from request")
+	}
+	if reservedUsername(username) {
+		return rooms.User{}, toStatus(errReservedUsername)
+	}
+
+	return rooms.User{Name: username}, nil
+}
+
+// moderatedRoom resolves the room a request addresses, failing unless the
+// caller is its owner or one of its moderators and the authorizer lets
+// them moderate it.
+func (g *Gateway) moderatedRoom(r *http.Request, idOrName string) (rooms.Room, error) {
+	ctx := r.Context()
+
+	user, err := caller(r)
+	if err != nil {
+		return rooms.Room{}, err
+	}
+
+	room, err := g.service.interactor().ResolveRoom(ctx, idOrName)
+	if err != nil {
+		return rooms.Room{}, toStatus(err)
+	}
+
+	if !room.Moderates(user.Identity()) {
+		return rooms.Room{}, toStatus(rooms.ErrForbidden)
+	}
+
+	if err := g.service.authorize(ctx, user, rooms.ActionModerate, room.Id, room.Name); err != nil {
+		return rooms.Room{}, toStatus(err)
+	}
+
+	return room, nil
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	runtime.HTTPError(r.Context(), g.mux, g.marshaler, w, r, toStatus(err))
+}
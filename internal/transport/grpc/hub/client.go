@@ -0,0 +1,290 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+)
+
+var (
+	ErrQueueFull    = errors.New("client outbound queue is full")
+	ErrSlowConsumer = errors.New("client disconnected for not keeping up with outbound messages")
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// SlowConsumerPolicy decides what happens when a client's outbox is full.
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDrop discards the message that did not fit into the outbox.
+	PolicyDrop SlowConsumerPolicy = iota
+	// PolicyDisconnect closes the client so its JoinRoom stream terminates.
+	PolicyDisconnect
+)
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch s {
+	case "", "drop":
+		return PolicyDrop, nil
+	case "disconnect":
+		return PolicyDisconnect, nil
+	default:
+		return PolicyDrop, errors.New("unknown slow consumer policy: " + s)
+	}
+}
+
+// Client is a single JoinRoom stream registered in a room. Messages are
+// queued into a bounded outbox and written by a dedicated goroutine, so a
+// stuck stream never blocks the goroutine that produced the message.
+type Client struct {
+	User rooms.User
+	Room string
+
+	hub    *Hub
+	stream Stream
+	outbox chan Message
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+
+	pauseOnce sync.Once
+	pause     chan struct{}
+	// stopped is closed once the writer has returned. broken is closed
+	// before if the writer returned because writing failed, with unsent
+	// holding the message it could not write and writeErr the failure.
+	stopped  chan struct{}
+	broken   chan struct{}
+	unsent   []Message
+	writeErr error
+
+	// replayed holds messages queued by Hub.Replay, which bypass the
+	// outbox so a long replay cannot overflow it. The writer sends them in
+	// pages of replayPage, taking turns with the outbox.
+	replayMu    sync.Mutex
+	replayed    []Message
+	replayReady chan struct{}
+}
+
+const replayPage = 32
+
+func newClient(h *Hub, room string, user rooms.User, stream Stream) *Client {
+	return &Client{
+		User:   user,
+		Room:   room,
+		hub:    h,
+		stream: stream,
+		outbox: make(chan Message, h.queueDepth),
+		done:   make(chan struct{}),
+
+		pause:   make(chan struct{}),
+		stopped: make(chan struct{}),
+		broken:  make(chan struct{}),
+
+		replayReady: make(chan struct{}, 1),
+	}
+}
+
+// Send queues a message for the client without blocking. It is not part of
+// the room's sequence.
+func (c *Client) Send(msg *proto.RoomMethod) error {
+	return c.enqueue(Message{Method: msg})
+}
+
+func (c *Client) enqueue(msg Message) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	select {
+	case c.outbox <- msg:
+		c.hub.metrics.enqueued.Add(1)
+		return nil
+	default:
+	}
+
+	if c.hub.policy == PolicyDisconnect {
+		c.hub.metrics.disconnected.Add(1)
+		c.Close(ErrSlowConsumer)
+		return ErrSlowConsumer
+	}
+
+	c.hub.metrics.dropped.Add(1)
+	return ErrQueueFull
+}
+
+// Close stops the writer goroutine. The first non-nil reason is kept and
+// reported by Err.
+func (c *Client) Close(reason error) {
+	c.closeOnce.Do(func() {
+		c.err = reason
+		close(c.done)
+	})
+}
+
+// Done is closed once the client has been closed, by Unregister,
+// Reattach, CloseRoom or the slow consumer policy.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client was closed. It is only meaningful after Done.
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Broken is closed once writing to the stream failed. The client stays
+// open, so messages keep queuing until it is reattached or unregistered.
+func (c *Client) Broken() <-chan struct{} {
+	return c.broken
+}
+
+// WriteErr returns why writing to the stream failed. It is only
+// meaningful after Broken.
+func (c *Client) WriteErr() error {
+	<-c.broken
+	return c.writeErr
+}
+
+// Pause stops the writer, leaving queued messages for Reattach to hand to
+// the next stream. It waits for a write in progress, so the stream must
+// be over or never block.
+func (c *Client) Pause() {
+	c.pauseOnce.Do(func() { close(c.pause) })
+	<-c.stopped
+}
+
+// Wait blocks until the writer has returned, after the client was closed
+// or paused. Like Pause, it waits for a write in progress.
+func (c *Client) Wait() {
+	<-c.stopped
+}
+
+func (c *Client) Pending() int {
+	return len(c.outbox)
+}
+
+func (c *Client) writeLoop() {
+	defer close(c.stopped)
+
+	for {
+		select {
+		case msg := <-c.outbox:
+			if err := c.write(msg); err != nil {
+				c.fail([]Message{msg}, err)
+				return
+			}
+			c.hub.metrics.delivered.Add(1)
+
+		case <-c.replayReady:
+			page, more := c.replayPage()
+			for i, msg := range page {
+				if err := c.write(msg); err != nil {
+					c.fail(page[i:], err)
+					return
+				}
+				c.hub.metrics.delivered.Add(1)
+			}
+			if more {
+				c.notifyReplay()
+			}
+
+		case <-c.pause:
+			return
+
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) fail(unsent []Message, err error) {
+	c.hub.metrics.sendErrors.Add(1)
+	c.unsent = unsent
+	c.writeErr = err
+	close(c.broken)
+}
+
+// replay queues msgs for the writer, failing while an earlier replay is
+// still being written.
+func (c *Client) replay(msgs []Message) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	if len(c.replayed) > 0 {
+		return ErrReplayPending
+	}
+	c.replayed = msgs
+	c.hub.metrics.enqueued.Add(uint64(len(msgs)))
+	c.notifyReplay()
+
+	return nil
+}
+
+func (c *Client) notifyReplay() {
+	select {
+	case c.replayReady <- struct{}{}:
+	default:
+	}
+}
+
+// replayPage takes the next page of replayed messages, reporting whether
+// more are left.
+func (c *Client) replayPage() ([]Message, bool) {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	n := min(len(c.replayed), replayPage)
+	page := c.replayed[:n]
+	c.replayed = c.replayed[n:]
+	if len(c.replayed) == 0 {
+		c.replayed = nil
+	}
+
+	return page, c.replayed != nil
+}
+
+// leftover returns the messages the client has not written, oldest first
+// apart from replayed ones. Only a stopped writer has handed back the
+// messages it failed to write.
+func (c *Client) leftover() []Message {
+	var msgs []Message
+	select {
+	case <-c.stopped:
+		msgs = append(msgs, c.unsent...)
+	default:
+	}
+
+	c.replayMu.Lock()
+	msgs = append(msgs, c.replayed...)
+	c.replayed = nil
+	c.replayMu.Unlock()
+
+	for {
+		select {
+		case msg := <-c.outbox:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func (c *Client) write(msg Message) error {
+	if stream, ok := c.stream.(SequencedStream); ok {
+		return stream.SendSequenced(msg)
+	}
+
+	return c.stream.Send(msg.Method)
+}
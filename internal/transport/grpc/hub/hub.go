@@ -0,0 +1,323 @@
+package hub
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultShards     = 32
+	DefaultQueueDepth = 256
+)
+
+var (
+	ErrAlreadyRegistered = errors.New("user already registered in room")
+	ErrNotRegistered     = errors.New("user not registered in room")
+	ErrReattached        = errors.New("client replaced by another stream of the same user")
+)
+
+// Stream is the part of a JoinRoom server stream the hub needs.
+type Stream interface {
+	Send(*proto.RoomMethod) error
+}
+
+type Options struct {
+	Shards     int
+	QueueDepth int
+	Policy     SlowConsumerPolicy
+	// ReplayBuffer is how many of its newest messages each room keeps for
+	// Replay.
+	ReplayBuffer int
+}
+
+type shard struct {
+	mu    sync.RWMutex
+	rooms map[string]map[uuid.UUID]*Client
+	// logs outlive a room's members, so sequence numbers keep growing when
+	// a room empties and fills again.
+	logs map[string]*replayLog
+}
+
+// Hub owns every registered JoinRoom stream, indexed by room, so fan-out
+// touches only the members of a single room. Rooms are spread over shards
+// to keep lock contention between unrelated rooms low.
+//
+// Every message sent into a room gets the next sequence number of each of
+// its recipients.
+type Hub struct {
+	shards       []*shard
+	queueDepth   int
+	policy       SlowConsumerPolicy
+	replayBuffer int
+	metrics      metrics
+}
+
+func New(opts Options) *Hub {
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultShards
+	}
+	if opts.QueueDepth <= 0 {
+		opts.QueueDepth = DefaultQueueDepth
+	}
+	if opts.ReplayBuffer <= 0 {
+		opts.ReplayBuffer = DefaultReplayBuffer
+	}
+
+	h := &Hub{
+		shards:       make([]*shard, opts.Shards),
+		queueDepth:   opts.QueueDepth,
+		policy:       opts.Policy,
+		replayBuffer: opts.ReplayBuffer,
+	}
+	for i := range h.shards {
+		h.shards[i] = &shard{
+			rooms: make(map[string]map[uuid.UUID]*Client),
+			logs:  make(map[string]*replayLog),
+		}
+	}
+
+	return h
+}
+
+func (h *Hub) shard(room string) *shard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(room))
+
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// log returns the replay log of a room, creating it if needed. The shard
+// must be locked for writing.
+func (h *Hub) log(s *shard, room string) *replayLog {
+	l, ok := s.logs[room]
+	if !ok {
+		l = &replayLog{limit: h.replayBuffer, direct: make(map[uuid.UUID]uint64)}
+		s.logs[room] = l
+	}
+
+	return l
+}
+
+// Register adds a stream to a room and starts its writer goroutine.
+func (h *Hub) Register(room string, user rooms.User, stream Stream) (*Client, error) {
+	s := h.shard(room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.rooms[room]
+	if !ok {
+		members = make(map[uuid.UUID]*Client)
+		s.rooms[room] = members
+	}
+
+	if _, ok := members[user.Id]; ok {
+		return nil, ErrAlreadyRegistered
+	}
+
+	client := newClient(h, room, user, stream)
+	members[user.Id] = client
+	h.metrics.connected.Add(1)
+
+	go client.writeLoop()
+
+	return client, nil
+}
+
+// Reattach atomically replaces a registered client with a new one for the
+// same user writing to stream, and closes the old client with
+// ErrReattached. backlog, then whatever the old client has not written, are
+// queued ahead of anything sent afterwards, as far as the outbox allows.
+// Pause the old client first so none of its messages are written to its
+// stream meanwhile.
+func (h *Hub) Reattach(client *Client, stream Stream, backlog []Message) (*Client, error) {
+	s := h.shard(client.Room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := s.rooms[client.Room]
+	if members[client.User.Id] != client {
+		return nil, ErrNotRegistered
+	}
+
+	next := newClient(h, client.Room, client.User, stream)
+	for _, msg := range backlog {
+		select {
+		case next.outbox <- msg:
+			h.metrics.enqueued.Add(1)
+		default:
+			h.metrics.dropped.Add(1)
+		}
+	}
+
+	// These were counted when first queued.
+	for _, msg := range client.leftover() {
+		select {
+		case next.outbox <- msg:
+		default:
+			h.metrics.dropped.Add(1)
+		}
+	}
+
+	members[client.User.Id] = next
+	client.Close(ErrReattached)
+
+	go next.writeLoop()
+
+	return next, nil
+}
+
+// Unregister removes a client from its room and stops its writer. It
+// returns once the writer is done with the stream, so the JoinRoom handler
+// owning the stream may return.
+func (h *Hub) Unregister(client *Client) {
+	client.Close(nil)
+	defer client.Wait()
+
+	s := h.shard(client.Room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.rooms[client.Room]
+	if !ok {
+		return
+	}
+
+	if members[client.User.Id] == client {
+		delete(members, client.User.Id)
+		h.metrics.connected.Add(-1)
+
+		if l, ok := s.logs[client.Room]; ok {
+			l.forget(client.User.Id)
+		}
+	}
+
+	if len(members) == 0 {
+		delete(s.rooms, client.Room)
+	}
+}
+
+// Clients returns a snapshot of the clients registered in a room.
+func (h *Hub) Clients(room string) []*Client {
+	s := h.shard(room)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := s.rooms[room]
+	clients := make([]*Client, 0, len(members))
+	for _, c := range members {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func (h *Hub) Client(room string, userID uuid.UUID) (*Client, bool) {
+	s := h.shard(room)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.rooms[room][userID]
+	return c, ok
+}
+
+// Broadcast queues a message for every client in a room. It never blocks on
+// a slow client; failures are reported per client in the returned error.
+// The shard stays locked while queueing, so every client sees the room's
+// messages in sequence order.
+func (h *Hub) Broadcast(room string, msg *proto.RoomMethod) error {
+	s := h.shard(room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := h.log(s, room)
+	l.append(msg, uuid.Nil)
+
+	var errs []error
+	for _, c := range s.rooms[room] {
+		if err := c.enqueue(Message{Seq: l.last(c.User.Id), Method: msg}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *Hub) SendTo(room string, userID uuid.UUID, msg *proto.RoomMethod) error {
+	s := h.shard(room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.rooms[room][userID]
+	if !ok {
+		return ErrNotRegistered
+	}
+
+	l := h.log(s, room)
+	l.append(msg, userID)
+
+	return c.enqueue(Message{Seq: l.last(userID), Method: msg})
+}
+
+// Seq returns the sequence number of the last message sent to userID in a
+// room. For a user who has not joined yet, that is the number of messages
+// sent to the whole room.
+func (h *Hub) Seq(room string, userID uuid.UUID) uint64 {
+	s := h.shard(room)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if l, ok := s.logs[room]; ok {
+		return l.last(userID)
+	}
+
+	return 0
+}
+
+// Replay queues the buffered messages of client's room following seq that
+// were sent to client's user, returning how many there were. It fails with
+// ErrReplayGap if some of them are no longer buffered, and with
+// ErrReplayPending while an earlier replay is still being sent. Replayed
+// messages do not take up room in the outbox and may interleave with new
+// ones, clients tell them apart by sequence number.
+func (h *Hub) Replay(client *Client, seq uint64) (int, error) {
+	s := h.shard(client.Room)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs, err := h.log(s, client.Room).after(seq, client.User.Id)
+	if err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	if err := client.replay(msgs); err != nil {
+		return 0, err
+	}
+
+	return len(msgs), nil
+}
+
+// CloseRoom closes every client in a room with the given reason and forgets
+// its messages. Their JoinRoom handlers observe Done and terminate the
+// streams.
+func (h *Hub) CloseRoom(room string, reason error) {
+	for _, c := range h.Clients(room) {
+		c.Close(reason)
+	}
+
+	s := h.shard(room)
+	s.mu.Lock()
+	delete(s.logs, room)
+	s.mu.Unlock()
+}
+
+func (h *Hub) Metrics() Metrics {
+	return h.metrics.snapshot()
+}
@@ -0,0 +1,33 @@
+package hub
+
+import "sync/atomic"
+
+type metrics struct {
+	connected    atomic.Int64
+	enqueued     atomic.Uint64
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+	sendErrors   atomic.Uint64
+}
+
+// Metrics is a point-in-time snapshot of hub counters.
+type Metrics struct {
+	Connected    int64  `json:"connected"`
+	Enqueued     uint64 `json:"enqueued"`
+	Delivered    uint64 `json:"delivered"`
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+	SendErrors   uint64 `json:"send_errors"`
+}
+
+func (m *metrics) snapshot() Metrics {
+	return Metrics{
+		Connected:    m.connected.Load(),
+		Enqueued:     m.enqueued.Load(),
+		Delivered:    m.delivered.Load(),
+		Dropped:      m.dropped.Load(),
+		Disconnected: m.disconnected.Load(),
+		SendErrors:   m.sendErrors.Load(),
+	}
+}
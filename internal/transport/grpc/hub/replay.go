@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/google/uuid"
+)
+
+const DefaultReplayBuffer = 512
+
+var (
+	ErrReplayGap     = errors.New("requested messages are no longer buffered")
+	ErrReplayPending = errors.New("an earlier replay is still being sent")
+)
+
+// Message is a room method together with its position among the messages
+// its recipient was sent. Messages sent to a single client outside of the
+// room order, such as replies to its own requests, have a zero Seq.
+type Message struct {
+	Seq    uint64
+	Method *proto.RoomMethod
+}
+
+// SequencedStream is implemented by streams that want to see the sequence
+// numbers of the messages they are sent.
+type SequencedStream interface {
+	Stream
+	SendSequenced(Message) error
+}
+
+type logEntry struct {
+	method *proto.RoomMethod
+	// to is the only recipient of the message, uuid.Nil for the whole room.
+	to uuid.UUID
+}
+
+// replayLog keeps the newest messages of a room. Messages are numbered per
+// recipient: a user's n-th message, whether sent to the whole room or to
+// them alone, has sequence number n, counting every message sent to the
+// whole room before they joined. So nobody sees holes left by messages
+// sent to someone else.
+type replayLog struct {
+	// seq counts the messages sent to the whole room.
+	seq uint64
+	// direct counts the messages sent to a single user.
+	direct  map[uuid.UUID]uint64
+	limit   int
+	entries []logEntry
+}
+
+// append logs a message sent to to, or to the whole room if to is uuid.Nil.
+func (l *replayLog) append(method *proto.RoomMethod, to uuid.UUID) {
+	if to == uuid.Nil {
+		l.seq++
+	} else {
+		l.direct[to]++
+	}
+
+	if len(l.entries) >= l.limit {
+		l.entries = l.entries[1:]
+	}
+	l.entries = append(l.entries, logEntry{method: method, to: to})
+}
+
+// last returns the sequence number of the last message userID was sent.
+func (l *replayLog) last(userID uuid.UUID) uint64 {
+	return l.seq + l.direct[userID]
+}
+
+// forget drops the count of messages sent to userID once they left.
+func (l *replayLog) forget(userID uuid.UUID) {
+	delete(l.direct, userID)
+}
+
+// after returns the messages following seq that userID received.
+func (l *replayLog) after(seq uint64, userID uuid.UUID) ([]Message, error) {
+	// Entries do not carry their numbers, since those differ between
+	// users, so they are counted back from the user's last one.
+	n := l.last(userID)
+
+	var msgs []Message
+	for i := len(l.entries) - 1; i >= 0 && n > seq; i-- {
+		e := l.entries[i]
+		if e.to != uuid.Nil && e.to != userID {
+			continue
+		}
+
+		msgs = append(msgs, Message{Seq: n, Method: e.method})
+		n--
+	}
+
+	if n > seq {
+		return nil, ErrReplayGap
+	}
+
+	slices.Reverse(msgs)
+
+	return msgs, nil
+}
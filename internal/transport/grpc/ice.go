@@ -0,0 +1,256 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SignalingOptions configure how the service relays signaling messages
+// between peers.
+type SignalingOptions struct {
+	// CandidateBuffer is how many ICE candidates a room keeps for each
+	// target that has not joined yet, zero disables buffering.
+	CandidateBuffer int
+	CandidateTTL    time.Duration
+	// SDPPolicy is enforced on every relayed SDP.
+	SDPPolicy signaling.Policy
+
+	// STUNURLs and TURNURLs are handed to room members as their ice
+	// servers. TURN issues their TURN credentials, without it TURN servers
+	// are not handed out.
+	STUNURLs []string
+	TURNURLs []string
+	TURN     signaling.TURNIssuer
+}
+
+// iceCandidateJSON is the text of SendIceCandidate and IceCandidateReceived,
+// which carry nothing but a string. Its fields follow RTCIceCandidateInit.
+// An empty candidate marks the end of candidates, like in browsers.
+type iceCandidateJSON struct {
+	Candidate        string  `json:"candidate"`
+	SdpMid           *string `json:"sdpMid,omitempty"`
+	SdpMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment string  `json:"usernameFragment,omitempty"`
+	EndOfCandidates  bool    `json:"endOfCandidates,omitempty"`
+	// To is the user id of the recipient, From the one of the sender.
+	To   string `json:"to,omitempty"`
+	From string `json:"from,omitempty"`
+}
+
+// relayCandidate validates an ICE candidate sent by user and passes it on
+// to its target, a member of the room or a user waiting in its lobby.
+// Candidates the room's candidate policy hides are dropped without an
+// error, since browsers gather them regardless.
+func (s *RoomsService) relayCandidate(ctx context.Context, interactor rooms.Interactor, room rooms.Room, user rooms.User, text string) error {
+	candidate := iceCandidateJSON{}
+	if err := json.Unmarshal([]byte(text), &candidate); err != nil {
+		return fmt.Errorf("%w: %v", signaling.ErrInvalidCandidate, err)
+	}
+
+	target, err := uuid.Parse(candidate.To)
+	if err != nil {
+		return fmt.Errorf("%w: to must be a user id", signaling.ErrInvalidCandidate)
+	}
+	if target == user.Id {
+		return fmt.Errorf("%w: cannot send candidates to yourself", signaling.ErrInvalidCandidate)
+	}
+
+	if candidate.Candidate == "" {
+		candidate.EndOfCandidates = true
+	} else {
+		filtered, keep, err := room.Settings.CandidatePolicy.FilterCandidate(candidate.Candidate)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			return nil
+		}
+		candidate.Candidate = filtered
+
+		if candidate.SdpMid == nil && candidate.SdpMLineIndex == nil {
+			return fmt.Errorf("%w: sdpMid or sdpMLineIndex is required", signaling.ErrInvalidCandidate)
+		}
+	}
+
+	// Only users known to the room may have candidates kept for them.
+	current, err := interactor.GetRoom(ctx, room.Id)
+	if err != nil {
+		return err
+	}
+	if _, ok := current.User(target); !ok && !current.Waiting(target) {
+		return signaling.ErrUnknownTarget
+	}
+
+	candidate.To = ""
+	candidate.From = user.Id.String()
+
+	relayed, err := json.Marshal(candidate)
+	if err != nil {
+		return err
+	}
+
+	return s.candidates.relay(room.Id, target, &proto.RoomMethod{
+		Method: &proto.RoomMethod_IceCandidateReceived{
+			IceCandidateReceived: &proto.IceCandidateReceivedNotification{
+				Candidate: string(relayed),
+				Username:  user.Name,
+			},
+		},
+	})
+}
+
+type pendingCandidate struct {
+	to      uuid.UUID
+	method  *proto.RoomMethod
+	expires time.Time
+}
+
+// candidateBuffer holds ICE candidates sent to users who are not connected
+// to the room yet, until they join.
+type candidateBuffer struct {
+	hub     *hub.Hub
+	limit   int
+	ttl     time.Duration
+	mu      sync.Mutex
+	pending map[string][]pendingCandidate
+}
+
+func newCandidateBuffer(h *hub.Hub, opts SignalingOptions) *candidateBuffer {
+	return &candidateBuffer{
+		hub:     h,
+		limit:   opts.CandidateBuffer,
+		ttl:     opts.CandidateTTL,
+		pending: make(map[string][]pendingCandidate),
+	}
+}
+
+// relay sends method to a user, or keeps it until they join. Candidates
+// already waiting for the user are not overtaken.
+func (b *candidateBuffer) relay(room string, to uuid.UUID, method *proto.RoomMethod) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(room, time.Now())
+
+	if !slices.ContainsFunc(b.pending[room], func(p pendingCandidate) bool { return p.to == to }) {
+		err := b.hub.SendTo(room, to, method)
+		if !errors.Is(err, hub.ErrNotRegistered) {
+			return err
+		}
+	}
+
+	if b.limit == 0 {
+		return hub.ErrNotRegistered
+	}
+	waiting := 0
+	for _, p := range b.pending[room] {
+		if p.to == to {
+			waiting++
+		}
+	}
+	if waiting >= b.limit {
+		return signaling.ErrCandidateBufferFull
+	}
+
+	b.pending[room] = append(b.pending[room], pendingCandidate{to: to, method: method, expires: time.Now().Add(b.ttl)})
+
+	return nil
+}
+
+// flush sends a user the candidates that were waiting for them. It must be
+// called once the user is registered in the hub.
+func (b *candidateBuffer) flush(room string, to uuid.UUID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(room, time.Now())
+
+	pending, ok := b.pending[room]
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	b.pending[room] = slices.DeleteFunc(pending, func(p pendingCandidate) bool {
+		if p.to != to {
+			return false
+		}
+
+		if err := b.hub.SendTo(room, to, p.method); err != nil {
+			errs = append(errs, err)
+		}
+		return true
+	})
+
+	if len(b.pending[room]) == 0 {
+		delete(b.pending, room)
+	}
+
+	return errors.Join(errs...)
+}
+
+// prune drops the expired candidates of a room, and the room once none
+// are left. The buffer must be locked.
+func (b *candidateBuffer) prune(room string, now time.Time) {
+	b.pending[room] = slices.DeleteFunc(b.pending[room], func(p pendingCandidate) bool {
+		return now.After(p.expires)
+	})
+
+	if len(b.pending[room]) == 0 {
+		delete(b.pending, room)
+	}
+}
+
+// expire drops the expired candidates of every room, including rooms
+// nobody sends candidates into anymore.
+func (b *candidateBuffer) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for room := range b.pending {
+		b.prune(room, now)
+	}
+}
+
+func (b *candidateBuffer) closeRoom(room string) {
+	b.mu.Lock()
+	delete(b.pending, room)
+	b.mu.Unlock()
+}
+
+// ExpireCandidates drops expired buffered candidates every interval until
+// ctx is done, so candidates for users who never join do not pile up.
+func (s *RoomsService) ExpireCandidates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.candidates.expire()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flushCandidates delivers the candidates sent to user before they joined.
+func (s *RoomsService) flushCandidates(ctx context.Context, roomID string, user rooms.User) {
+	if err := s.candidates.flush(roomID, user.Id); err != nil {
+		s.logger.Warn(ctx, "couldnt deliver buffered ice candidates", zap.String("room_id", roomID), zap.String("username", user.Name), zap.Error(err))
+	}
+}
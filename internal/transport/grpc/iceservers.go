@@ -0,0 +1,79 @@
+package grpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"google.golang.org/grpc/codes"
+)
+
+// iceServerJSON follows RTCIceServer, so clients can pass the list to
+// RTCPeerConnection as it is.
+type iceServerJSON struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type iceServersJSON struct {
+	IceServers []iceServerJSON `json:"ice_servers"`
+	// ExpiresAt is when the TURN credentials stop working, absent without
+	// TURN servers.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// iceServers lists the STUN and TURN servers for user, with fresh TURN
+// credentials.
+func (s *RoomsService) iceServers(user rooms.User) iceServersJSON {
+	servers := iceServersJSON{IceServers: []iceServerJSON{}}
+
+	if len(s.stunURLs) > 0 {
+		servers.IceServers = append(servers.IceServers, iceServerJSON{URLs: s.stunURLs})
+	}
+
+	if len(s.turnURLs) > 0 && s.turn != nil {
+		credentials := s.turn.Issue(user.Id.String())
+		servers.IceServers = append(servers.IceServers, iceServerJSON{
+			URLs:       s.turnURLs,
+			Username:   credentials.Username,
+			Credential: credentials.Password,
+		})
+		servers.ExpiresAt = &credentials.ExpiresAt
+	}
+
+	return servers
+}
+
+// iceServers serves the ICE servers to a member of the room.
+func (g *Gateway) iceServers(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	ctx := r.Context()
+
+	requester, err := caller(r)
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	room, err := g.service.interactor().ResolveRoom(ctx, params["id"])
+	if err != nil {
+		g.writeError(w, r, toStatus(err))
+		return
+	}
+
+	for _, user := range room.Users {
+		if user.Identity() == requester.Identity() {
+			if err := g.service.authorize(ctx, user, rooms.ActionICEServers, room.Id, room.Name); err != nil {
+				g.writeError(w, r, toStatus(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(g.service.iceServers(user))
+			return
+		}
+	}
+
+	g.writeError(w, r, statusWithReason(codes.PermissionDenied, "FORBIDDEN", "only members of the room get ice servers"))
+}
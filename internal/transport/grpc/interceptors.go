@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	requestIDMetadata     = "x-request-id"
+	authorizationMetadata = "authorization"
+)
+
+// wrappedStream lets stream interceptors replace the stream context.
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+// withRequestID stores the caller supplied request id, or a fresh one, in
+// ctx under logger.RequestID so it reaches every log line down to storage.
+func withRequestID(ctx context.Context) context.Context {
+	requestID := ""
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDMetadata); len(ids) > 0 {
+			requestID = ids[0]
+		}
+	}
+
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	return context.WithValue(ctx, logger.RequestID, requestID)
+}
+
+func RequestIDUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withRequestID(ctx), req)
+}
+
+func RequestIDStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: withRequestID(ss.Context())})
+}
+
+// authenticate verifies a bearer authorization value and stores the
+// identity of its token in ctx.
+func authenticate(ctx context.Context, verifier *auth.Verifier, authorization string) (context.Context, error) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return nil, statusWithReason(codes.Unauthenticated, "MISSING_TOKEN", "missing bearer token")
+	}
+
+	identity, err := verifier.Verify(token)
+	if err != nil {
+		return nil, statusWithReason(codes.Unauthenticated, "INVALID_TOKEN", err.Error())
+	}
+
+	return auth.WithIdentity(ctx, identity), nil
+}
+
+func authenticateIncoming(ctx context.Context, verifier *auth.Verifier) (context.Context, error) {
+	authorization := ""
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(authorizationMetadata); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	return authenticate(ctx, verifier, authorization)
+}
+
+// AuthUnaryInterceptor rejects calls without a valid JWT with
+// codes.Unauthenticated.
+func AuthUnaryInterceptor(verifier *auth.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticateIncoming(ctx, verifier)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// AuthStreamInterceptor is AuthUnaryInterceptor for streams.
+func AuthStreamInterceptor(verifier *auth.Verifier) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateIncoming(ss.Context(), verifier)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
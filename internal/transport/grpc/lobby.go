@@ -0,0 +1,163 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// lobby holds the JoinRoom streams of users waiting for admission. The
+// decision is sent on a waiter's channel: nil to join, an error otherwise.
+// A user may wait in several rooms at once.
+type lobby struct {
+	mu      sync.Mutex
+	waiting map[waiterKey]chan error
+}
+
+type waiterKey struct {
+	room string
+	user uuid.UUID
+}
+
+func newLobby() *lobby {
+	return &lobby{waiting: make(map[waiterKey]chan error)}
+}
+
+// wait registers a waiter, failing if the user already waits in room.
+func (l *lobby) wait(room string, userID uuid.UUID) (chan error, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := waiterKey{room: room, user: userID}
+	if _, ok := l.waiting[key]; ok {
+		return nil, rooms.ErrUsernameTaken
+	}
+
+	decision := make(chan error, 1)
+	l.waiting[key] = decision
+
+	return decision, nil
+}
+
+// resolve reports whether the user was still waiting in room.
+func (l *lobby) resolve(room string, userID uuid.UUID, decision error) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := waiterKey{room: room, user: userID}
+	w, ok := l.waiting[key]
+	if !ok {
+		return false
+	}
+
+	delete(l.waiting, key)
+	w <- decision
+
+	return true
+}
+
+func (l *lobby) cancel(room string, userID uuid.UUID, decision chan error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := waiterKey{room: room, user: userID}
+	if l.waiting[key] == decision {
+		delete(l.waiting, key)
+	}
+}
+
+// closeRoom resolves everyone waiting for room with reason.
+func (l *lobby) closeRoom(room string, reason error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, w := range l.waiting {
+		if key.room == room {
+			delete(l.waiting, key)
+			w <- reason
+		}
+	}
+}
+
+// waitForAdmission keeps user in the lobby of room until a moderator
+// decides, returning nil once the user may join.
+func (s *RoomsService) waitForAdmission(ctx context.Context, interactor rooms.Interactor, roomID string, user rooms.User, credentials rooms.Credentials) error {
+	decision, err := s.lobby.wait(roomID, user.Id)
+	if err != nil {
+		return err
+	}
+	defer s.lobby.cancel(roomID, user.Id, decision)
+
+	if err := interactor.EnterLobby(ctx, roomID, user, credentials); err != nil {
+		return err
+	}
+
+	s.notifyModerators(ctx, interactor, roomID, dispatcherMethod(joinRequestEvent(user)))
+
+	select {
+	case err := <-decision:
+		return err
+
+	case <-ctx.Done():
+		s.dropAdmission(ctx, interactor, roomID, user)
+		return ctx.Err()
+	}
+}
+
+// dropAdmission takes user out of the lobby once they stopped waiting, or
+// drops their admission if they were admitted but did not join.
+func (s *RoomsService) dropAdmission(ctx context.Context, interactor rooms.Interactor, roomID string, user rooms.User) {
+	err := interactor.LeaveLobby(context.WithoutCancel(ctx), roomID, user.Id)
+	if err != nil && !errors.Is(err, rooms.ErrNotWaiting) && !errors.Is(err, rooms.ErrRoomNotFound) {
+		s.logger.Error(ctx, "couldnt leave lobby", zap.String("room_id", roomID), zap.String("username", user.Name), zap.Error(err))
+	}
+}
+
+// notifyModerators sends msg to every room user allowed to moderate.
+func (s *RoomsService) notifyModerators(ctx context.Context, interactor rooms.Interactor, roomID string, msg *proto.RoomMethod) {
+	roomUsers, err := interactor.GetRoomUsers(ctx, roomID)
+	if err != nil {
+		s.logger.Warn(ctx, "couldnt fetch room users", zap.String("room_id", roomID), zap.Error(err))
+		return
+	}
+
+	for _, u := range roomUsers {
+		if !u.Role.Can(rooms.PermissionModerate) {
+			continue
+		}
+
+		if err := s.hub.SendTo(roomID, u.Id, msg); err != nil {
+			s.logger.Warn(ctx, "couldnt notify moderator", zap.String("room_id", roomID), zap.String("to", u.Name), zap.Error(err))
+		}
+	}
+}
+
+// sendJoinRequests tells a moderator who just joined about everyone
+// already waiting.
+func (s *RoomsService) sendJoinRequests(ctx context.Context, interactor rooms.Interactor, client *hub.Client, roomID string) error {
+	room, err := interactor.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range room.Pending {
+		if err := client.Send(dispatcherMethod(joinRequestEvent(u))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinRequestEvent(user rooms.User) dispatcherEvent {
+	return dispatcherEvent{
+		Type: "join_request",
+		Data: roomUserJSON{Id: user.Id.String(), Username: user.Name},
+	}
+}
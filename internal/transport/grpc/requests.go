@@ -0,0 +1,109 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const requestPrefix = commandPrefix + "request "
+
+// sequencedStream frames the room messages of a JoinRoom stream in "event"
+// dispatcher messages carrying their sequence number, since RoomMethod has
+// no field for it. Messages outside the room sequence are sent as they are.
+type sequencedStream struct {
+	proto.RoomsService_JoinRoomServer
+}
+
+func (s sequencedStream) SendSequenced(msg hub.Message) error {
+	if msg.Seq == 0 {
+		return s.Send(msg.Method)
+	}
+
+	data, err := protojson.Marshal(msg.Method)
+	if err != nil {
+		return err
+	}
+
+	return s.Send(dispatcherMethod(dispatcherEvent{Type: "event", Seq: msg.Seq, Data: json.RawMessage(data)}))
+}
+
+// outboundStream returns what the hub should write to for stream, framing
+// messages with sequence numbers if the client asked for them.
+func outboundStream(stream proto.RoomsService_JoinRoomServer) (hub.Stream, error) {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+
+	values := md.Get(sequencedMetadata)
+	if len(values) == 0 {
+		return stream, nil
+	}
+
+	sequenced, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return nil, invalidArgument("INVALID_METADATA", "sequenced must be a boolean")
+	}
+	if !sequenced {
+		return stream, nil
+	}
+
+	return sequencedStream{stream}, nil
+}
+
+// unwrapRequest extracts a room method sent as "/request <id> <method>",
+// where method is the protojson encoding of a RoomMethod. The sender gets
+// an "ack" or "error" dispatcher message with the same request id for it.
+// Other methods are returned as they are, with no request id.
+func unwrapRequest(msg *proto.RoomMethod) (string, *proto.RoomMethod, error) {
+	m, ok := msg.Method.(*proto.RoomMethod_SendMessage)
+	if !ok || !strings.HasPrefix(m.SendMessage.Text, requestPrefix) {
+		return "", msg, nil
+	}
+
+	id, body, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(m.SendMessage.Text, requestPrefix)), " ")
+	if !ok {
+		return "", nil, invalidArgument("INVALID_REQUEST", "usage: /request <id> <method>")
+	}
+
+	inner := &proto.RoomMethod{}
+	if err := protojson.Unmarshal([]byte(body), inner); err != nil {
+		return id, nil, invalidArgument("INVALID_REQUEST", "invalid method: %v", err)
+	}
+
+	if next, ok := inner.Method.(*proto.RoomMethod_SendMessage); ok && strings.HasPrefix(next.SendMessage.Text, requestPrefix) {
+		return id, nil, invalidArgument("INVALID_REQUEST", "requests cannot be nested")
+	}
+
+	return id, inner, nil
+}
+
+// reply reports the outcome of a room method to its sender. Successful
+// methods are only acknowledged when they carry a request id.
+func (s *RoomsService) reply(ctx context.Context, client *hub.Client, requestID string, err error) {
+	var errs []error
+	switch {
+	case err != nil:
+		// Methods with several recipients fail separately for each.
+		failures := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			failures = joined.Unwrap()
+		}
+
+		for _, failure := range failures {
+			errs = append(errs, sendError(client, requestID, failure))
+		}
+	case requestID != "":
+		errs = append(errs, client.Send(dispatcherMethod(dispatcherEvent{Type: "ack", RequestID: requestID})))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		s.logger.Warn(ctx, "couldnt reply to user", zap.String("room_id", client.Room), zap.String("username", client.User.Name), zap.Error(err))
+	}
+}
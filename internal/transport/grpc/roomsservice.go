@@ -2,27 +2,45 @@ package grpc
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/google/uuid"
 	"io"
 	"net/http"
-	"slices"
+	"strconv"
+	"time"
 
 	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
 	"github.com/gitgernit/videochat-rooms/internal/domain/pingpong"
 	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
 	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 const (
-	usernameMetadata   = "username"
-	roomNameMetadata   = "room_name"
-	dispatcherUsername = "dispatcher"
+	usernameMetadata        = "username"
+	roomNameMetadata        = "room_name"
+	idempotencyKeyMetadata  = "idempotency-key"
+	roomIDMetadata          = "room-id"
+	userIDMetadata          = "user-id"
+	roleMetadata            = "role"
+	maxParticipantsMetadata = "max-participants"
+	overflowModeMetadata    = "overflow-mode"
+	roomPrivateMetadata     = "room-private"
+	roomPasswordMetadata    = "room-password"
+	roomLobbyMetadata       = "room-lobby"
+	inviteMetadata          = "invite"
+	sessionTokenMetadata    = "session-token"
+	sequencedMetadata       = "sequenced"
+	roomSeqMetadata         = "room-seq"
+	candidatePolicyMetadata = "candidate-policy"
+	dispatcherUsername      = "dispatcher"
 )
 
 func RoomsHeaderMatcher(key string) (string, bool) {
@@ -31,6 +49,30 @@ func RoomsHeaderMatcher(key string) (string, bool) {
 		return usernameMetadata, true
 	case "Room-Name":
 		return roomNameMetadata, true
+	case "X-Request-Id":
+		return requestIDMetadata, true
+	case "Idempotency-Key":
+		return idempotencyKeyMetadata, true
+	case "Max-Participants":
+		return maxParticipantsMetadata, true
+	case "Overflow-Mode":
+		return overflowModeMetadata, true
+	case "Room-Private":
+		return roomPrivateMetadata, true
+	case "Room-Password":
+		return roomPasswordMetadata, true
+	case "Room-Lobby":
+		return roomLobbyMetadata, true
+	case "Invite":
+		return inviteMetadata, true
+	case "Authorization":
+		return authorizationMetadata, true
+	case "Session-Token":
+		return sessionTokenMetadata, true
+	case "Sequenced":
+		return sequencedMetadata, true
+	case "Candidate-Policy":
+		return candidatePolicyMetadata, true
 	default:
 		return key, false
 	}
@@ -50,21 +92,49 @@ func WebsocketParamMutator(incoming *http.Request, outgoing *http.Request) *http
 
 type RoomsService struct {
 	proto.UnimplementedRoomsServiceServer
-	logger               logger.Logger
-	repository           rooms.Repository
-	incomingRoomsChannel chan string
-	Users                map[rooms.User]grpc.ServerStream
+	logger     logger.Logger
+	repository rooms.Repository
+	events     *pubsub.Broker[rooms.Event]
+	invites    rooms.InviteCodec
+	authorizer rooms.Authorizer
+	hub        *hub.Hub
+	lobby      *lobby
+	config     rooms.Config
+
+	sessions       *sessions
+	sessionOptions SessionOptions
+	candidates     *candidateBuffer
+	sdpPolicy      signaling.Policy
+	turn           signaling.TURNIssuer
+	stunURLs       []string
+	turnURLs       []string
 }
 
-func NewRoomsService(logger logger.Logger, repository rooms.Repository, incomingRoomsChannel chan string) *RoomsService {
+func NewRoomsService(logger logger.Logger, repository rooms.Repository, events *pubsub.Broker[rooms.Event], invites rooms.InviteCodec, authorizer rooms.Authorizer, hub *hub.Hub, sessionOptions SessionOptions, signalingOptions SignalingOptions, config rooms.Config) *RoomsService {
 	return &RoomsService{
-		logger:               logger,
-		repository:           repository,
-		Users:                make(map[rooms.User]grpc.ServerStream),
-		incomingRoomsChannel: incomingRoomsChannel,
+		logger:     logger,
+		repository: repository,
+		events:     events,
+		invites:    invites,
+		authorizer: authorizer,
+		hub:        hub,
+		lobby:      newLobby(),
+		config:     config,
+
+		sessions:       newSessions(),
+		sessionOptions: sessionOptions,
+		candidates:     newCandidateBuffer(hub, signalingOptions),
+		sdpPolicy:      signalingOptions.SDPPolicy,
+		turn:           signalingOptions.TURN,
+		stunURLs:       signalingOptions.STUNURLs,
+		turnURLs:       signalingOptions.TURNURLs,
 	}
 }
 
+func (s *RoomsService) interactor() rooms.Interactor {
+	return rooms.NewInteractor(s.logger, s.repository, s.events, s.invites, s.config)
+}
+
 func (s *RoomsService) PingPong(stream proto.RoomsService_PingPongServer) error {
 	interactor := pingpong.Interactor{}
 
@@ -98,15 +168,28 @@ func (s *RoomsService) PingPong(stream proto.RoomsService_PingPongServer) error
 func (s *RoomsService) ListenForRooms(in *proto.ListenForRoomsRequest, stream proto.RoomsService_ListenForRoomsServer) error {
 	ctx := stream.Context()
 
+	subscription := s.events.Subscribe()
+	defer subscription.Close()
+
 	for {
 		select {
-		case roomName := <-s.incomingRoomsChannel:
-			notification := &proto.NewRoomNotification{Name: roomName}
+		case event := <-subscription.C():
+			// NewRoomNotification cannot say a room got locked, so an
+			// unlocked room is announced again as joinable instead.
+			if (event.Type != rooms.EventRoomCreated && event.Type != rooms.EventRoomUnlocked) || event.Private {
+				continue
+			}
+
+			notification := &proto.NewRoomNotification{Name: event.Room}
 			if err := stream.Send(notification); err != nil {
-				s.logger.Error(ctx, "could not send room notification", zap.String("room_id", roomName), zap.Error(err))
-				return status.Error(codes.Internal, err.Error())
+				s.logger.Error(ctx, "could not send room notification", zap.String("room_id", event.RoomID), zap.Error(err))
+				return statusWithReason(codes.Internal, "INTERNAL", err.Error())
 			}
 
+		case <-subscription.Done():
+			s.logger.Warn(ctx, "room listener dropped", zap.Error(subscription.Err()))
+			return statusWithReason(codes.ResourceExhausted, "SLOW_CONSUMER", subscription.Err().Error())
+
 		case <-ctx.Done():
 			return nil
 		}
@@ -114,198 +197,336 @@ func (s *RoomsService) ListenForRooms(in *proto.ListenForRoomsRequest, stream pr
 }
 
 func (s *RoomsService) CreateRoom(ctx context.Context, req *proto.CreateRoomRequest) (*proto.CreateRoomResponse, error) {
-	interactor := rooms.NewInteractor(s.logger, s.repository, s.incomingRoomsChannel)
+	interactor := s.interactor()
+
+	params := rooms.CreateRoomParams{Name: req.Name}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(idempotencyKeyMetadata); len(keys) > 0 {
+			params.IdempotencyKey = keys[0]
+		}
+
+		settings, err := roomSettingsFromMetadata(md)
+		if err != nil {
+			return nil, err
+		}
+		params.Settings = settings
+
+		if values := md.Get(maxParticipantsMetadata); len(values) > 0 {
+			maxParticipants, err := strconv.Atoi(values[0])
+			if err != nil || maxParticipants < 0 {
+				return nil, invalidArgument("INVALID_METADATA", "max participants must be a non-negative integer")
+			}
+			params.MaxParticipants = &maxParticipants
+		}
 
-	err := interactor.CreateRoom(req.Name)
+		if values := md.Get(roomPrivateMetadata); len(values) > 0 {
+			private, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, invalidArgument("INVALID_METADATA", "room private must be a boolean")
+			}
+			params.Private = private
+		}
+
+		if passwords := md.Get(roomPasswordMetadata); len(passwords) > 0 {
+			params.Password = passwords[0]
+		}
+
+		if values := md.Get(roomLobbyMetadata); len(values) > 0 {
+			lobby, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, invalidArgument("INVALID_METADATA", "room lobby must be a boolean")
+			}
+			params.Lobby = lobby
+		}
+
+	}
+
+	// Without authentication the username header is optional here, so
+	// the room may have no owner.
+	owner, err := userFromContext(ctx)
+	switch {
+	case err == nil:
+		params.Owner = owner.Identity()
+	case errors.Is(err, errReservedUsername):
+		return nil, toStatus(err)
+	}
+
+	if err := s.authorize(ctx, owner, rooms.ActionCreateRoom, "", req.Name); err != nil {
+		return nil, toStatus(err)
+	}
+
+	room, err := interactor.CreateRoom(ctx, params)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, toStatus(err)
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(roomIDMetadata, room.Id, candidatePolicyMetadata, string(room.Settings.CandidatePolicy))); err != nil {
+		s.logger.Warn(ctx, "couldnt set room id header", zap.String("room_id", room.Id), zap.Error(err))
 	}
 
-	return &proto.CreateRoomResponse{Name: req.Name}, nil
+	return &proto.CreateRoomResponse{Name: room.Name}, nil
 }
 
 func (s *RoomsService) JoinRoom(stream proto.RoomsService_JoinRoomServer) error {
 	ctx := stream.Context()
-	interactor := rooms.NewInteractor(s.logger, s.repository, s.incomingRoomsChannel)
+	interactor := s.interactor()
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Error(codes.InvalidArgument, "couldnt extract metadata from request")
+		return invalidArgument("INVALID_METADATA", "couldnt extract metadata from request")
 	}
 
-	usernames, ok := md["username"]
-	if !ok {
-		return status.Error(codes.InvalidArgument, "couldnt extract username from request")
+	if tokens := md.Get(sessionTokenMetadata); len(tokens) > 0 {
+		return s.resumeSession(stream, interactor, tokens[0])
 	}
-	username := usernames[0]
 
-	user := rooms.User{Name: username, Id: uuid.New()}
-	s.Users[user] = stream
-	defer delete(s.Users, user)
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return toStatus(err)
+	}
+	username := user.Name
 
 	roomNames, ok := md["room_name"]
 	if !ok {
-		return status.Error(codes.InvalidArgument, "couldnt extract room name from request")
+		return invalidArgument("INVALID_METADATA", "couldnt extract room name from request")
+	}
+
+	room, err := interactor.ResolveRoom(ctx, roomNames[0])
+	if err != nil {
+		return toStatus(err)
 	}
-	roomName := roomNames[0]
+	roomName := room.Id
 
-	roomUsers, err := interactor.GetRoomUsers(roomName)
+	outbound, err := outboundStream(stream)
 	if err != nil {
-		return status.Error(codes.Internal, "couldnt fetch room users")
+		return err
 	}
 
-	for _, u := range roomUsers {
-		if u.Name == username {
-			return status.Error(codes.InvalidArgument, "username already taken")
+	if err := s.authorize(ctx, user, rooms.ActionJoinRoom, room.Id, room.Name); err != nil {
+		return toStatus(err)
+	}
+
+	credentials := rooms.Credentials{}
+	if passwords := md.Get(roomPasswordMetadata); len(passwords) > 0 {
+		credentials.Password = passwords[0]
+	}
+	if invites := md.Get(inviteMetadata); len(invites) > 0 {
+		credentials.Invite = invites[0]
+	}
+
+	user, err = interactor.Authenticate(room, user, credentials)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	s.endDetached(context.WithoutCancel(ctx), interactor, roomName, user.Identity())
+
+	// Response headers are only sent once the user is in the room, so
+	// clients waiting in the lobby know they have been admitted.
+	admitted := room.NeedsAdmission(user)
+	if admitted {
+		if err := s.waitForAdmission(ctx, interactor, roomName, user, credentials); err != nil {
+			return toStatus(err)
 		}
 	}
 
-	allRooms, err := interactor.GetRooms()
+	joined, err := interactor.JoinRoom(ctx, roomName, user, credentials)
 	if err != nil {
-		s.logger.Error(ctx, "couldnt fetch all rooms")
-		return status.Error(codes.Internal, err.Error())
+		s.logger.Error(ctx, "couldnt join room", zap.String("room_id", roomName), zap.String("username", username), zap.Error(err))
+		if admitted {
+			s.dropAdmission(ctx, interactor, roomName, user)
+		}
+		return toStatus(err)
 	}
+	user = joined
 
-	allRoomsIDs := make([]string, len(allRooms))
-	for _, v := range allRooms {
-		allRoomsIDs = append(allRoomsIDs, v.Name)
+	abort := func(err error) error {
+		if err := interactor.LeaveRoom(context.WithoutCancel(ctx), roomName, user); err != nil {
+			s.logger.Error(ctx, err.Error(), zap.String("room_id", roomName), zap.String("username", user.Name))
+		}
+		return toStatus(err)
 	}
 
-	if !slices.Contains(allRoomsIDs, roomName) {
-		return status.Error(codes.InvalidArgument, "no such room with given id")
+	token, err := newSessionToken()
+	if err != nil {
+		return abort(err)
 	}
 
-	if err := interactor.JoinRoom(roomName, user); err != nil {
-		s.logger.Error(ctx, "couldnt join room", zap.String("room_id", roomName), zap.String("username", username))
-		return status.Error(codes.Internal, err.Error())
+	if err := s.sendHeader(stream, token, room, user); err != nil {
+		return abort(err)
 	}
 
-	err = s.sendRoomUsers(ctx, interactor, roomName)
+	client, err := s.hub.Register(roomName, user, outbound)
 	if err != nil {
-		return status.Error(codes.Internal, err.Error())
+		return abort(err)
 	}
-	defer func(s *RoomsService, ctx context.Context, interactor rooms.Interactor, roomID string) {
-		err := s.sendRoomUsers(ctx, interactor, roomID)
-		if err != nil {
-			s.logger.Error(ctx, "couldnt send room users upon user leaving room")
+
+	// The stream context is already cancelled by the time the stream ends,
+	// so leaving the room must not depend on it.
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	sess := s.sessions.start(token, roomName, user, client)
+
+	resumable, err := s.attach(ctx, stream, interactor, client, room, user, func() error {
+		if err := s.sendRoomUsers(ctx, interactor, roomName); err != nil {
+			return err
 		}
-	}(s, ctx, interactor, roomName)
-	defer func(interactor rooms.Interactor, id string, user rooms.User) {
-		err := interactor.LeaveRoom(id, user)
-		if err != nil {
-			s.logger.Error(ctx, err.Error(), zap.String("room_id", roomName), zap.String("username", user.Name))
+
+		s.flushCandidates(ctx, roomName, user)
+
+		if user.Role.Can(rooms.PermissionModerate) {
+			if err := s.sendJoinRequests(ctx, interactor, client, roomName); err != nil {
+				s.logger.Warn(ctx, "couldnt send join requests", zap.String("room_id", roomName), zap.Error(err))
+			}
 		}
-	}(interactor, roomName, user)
+
+		return nil
+	})
+
+	s.endSession(cleanupCtx, interactor, sess, client, resumable)
+
+	return err
+}
+
+// serve handles the room methods of a stream until it ends. The stream
+// may be resumed unless the user left or the server closed it.
+func (s *RoomsService) serve(ctx context.Context, stream proto.RoomsService_JoinRoomServer, interactor rooms.Interactor, client *hub.Client, room rooms.Room, user rooms.User) (bool, error) {
+	roomName := room.Id
+	username := user.Name
+
+	incoming := receive(ctx, stream)
 
 	for {
-		msg, err := stream.Recv()
+		var msg *proto.RoomMethod
 
-		if err == io.EOF {
-			return nil
+		select {
+		case r := <-incoming:
+			// Browsers can only end a stream by closing the websocket,
+			// which the gateway passes on as a half-close, so that is as
+			// resumable as a dropped stream. Users leave with /leave.
+			if r.err == io.EOF {
+				return true, nil
+			}
+
+			if r.err != nil {
+				return true, toStatus(r.err)
+			}
+
+			msg = r.msg
+
+		case <-ctx.Done():
+			return true, toStatus(ctx.Err())
+
+		case <-client.Done():
+			s.logger.Warn(ctx, "room client closed", zap.String("room_id", roomName), zap.String("username", username), zap.Error(client.Err()))
+			return false, clientClosedStatus(client.Err())
+
+		case <-client.Broken():
+			// The hub only closes clients for good, a failed write is a
+			// dropped stream like any other.
+			return true, toStatus(client.WriteErr())
 		}
 
+		requestID, msg, err := unwrapRequest(msg)
 		if err != nil {
-			return status.Error(codes.Internal, err.Error())
+			s.reply(ctx, client, requestID, err)
+			continue
 		}
 
-		method := msg.Method
+		if isLeave(msg) {
+			return false, nil
+		}
 
-		switch m := method.(type) {
-		case *proto.RoomMethod_SendMessage:
-			message := m.SendMessage
-			text := message.Text
+		// Roles may change while the user is in the room.
+		user, err := interactor.GetRoomUser(ctx, roomName, user.Id)
+		if err != nil {
+			return false, toStatus(err)
+		}
 
-			roomUsers, err := interactor.GetRoomUsers(roomName)
-			if err != nil {
-				s.logger.Error(ctx, "couldnt fetch room users")
-				return status.Error(codes.Internal, err.Error())
-			}
+		err = s.handleMethod(ctx, interactor, client, room, user, msg)
+		if errors.Is(err, errInvalidMethod) && requestID == "" {
+			return false, err
+		}
 
-			for streamUser, stream := range s.Users {
-				if slices.Contains(roomUsers, streamUser) {
-					userStream, ok := stream.(proto.RoomsService_JoinRoomServer)
-					if !ok {
-						s.logger.Error(ctx, "couldnt convert stream to JoinRoom server stream")
-						return status.Error(codes.Internal, "couldnt process all room users")
-					}
-
-					message := &proto.MessageReceivedNotification{Text: text, Username: user.Name}
-					method := &proto.RoomMethod{
-						Method: &proto.RoomMethod_MessageReceived{
-							MessageReceived: message,
-						},
-					}
-
-					err := userStream.Send(method)
-					if err != nil {
-						s.logger.Error(ctx, "couldnt send message")
-						return status.Error(codes.Internal, err.Error())
-					}
-				}
-			}
+		s.reply(ctx, client, requestID, err)
+	}
+}
 
-		case *proto.RoomMethod_SendSdp:
-			message := m.SendSdp
-			sdps := message.Sdp
+var errInvalidMethod = invalidArgument("INVALID_METHOD", "received invalid method")
 
-			roomUsers, err := interactor.GetRoomUsers(roomName)
-			if err != nil {
-				s.logger.Error(ctx, "couldnt fetch room users")
-				return status.Error(codes.Internal, err.Error())
-			}
+// handleMethod runs a single room method sent by user. Errors are reported
+// back to the sender.
+func (s *RoomsService) handleMethod(ctx context.Context, interactor rooms.Interactor, client *hub.Client, room rooms.Room, user rooms.User, msg *proto.RoomMethod) error {
+	roomName := room.Id
 
-			for _, sdp := range sdps {
-				user := roomUsers[0]
+	action, ok := methodAction(msg)
+	if !ok {
+		return errInvalidMethod
+	}
 
-				for _, roomUser := range roomUsers {
-					if roomUser.Name == sdp.Username {
-						user = roomUser
-						break
-					}
-				}
+	if err := s.authorize(ctx, user, action, room.Id, room.Name); err != nil {
+		return err
+	}
 
-				stream := s.Users[user]
+	if err := authorizeMethod(user, msg); err != nil {
+		return err
+	}
 
-				userStream, ok := stream.(proto.RoomsService_JoinRoomServer)
-				if !ok {
-					s.logger.Error(ctx, "couldnt convert stream to JoinRoom server stream")
-					return status.Error(codes.Internal, "couldnt process all room users")
-				}
+	switch m := msg.Method.(type) {
+	case *proto.RoomMethod_SendMessage:
+		text := m.SendMessage.Text
 
-				method := &proto.RoomMethod{
-					Method: &proto.RoomMethod_SdpReceived{
-						SdpReceived: &proto.SDPReceivedNotification{
-							Type: sdp.Type,
-							Sdp:  sdp.Sdp,
-							To:   sdp.Username,
-							From: username,
-						},
-					},
-				}
+		if cmd, ok := parseCommand(text); ok {
+			return s.handleCommand(ctx, interactor, client, roomName, user, cmd)
+		}
 
-				err := userStream.Send(method)
-				if err != nil {
-					s.logger.Error(ctx, "couldnt send sdp")
-					return status.Error(codes.Internal, err.Error())
-				}
-			}
+		method := &proto.RoomMethod{
+			Method: &proto.RoomMethod_MessageReceived{
+				MessageReceived: &proto.MessageReceivedNotification{Text: text, Username: user.Name},
+			},
+		}
 
-		default:
-			return status.Error(codes.InvalidArgument, "received invalid method")
+		if err := s.hub.Broadcast(roomName, method); err != nil {
+			s.logger.Warn(ctx, "couldnt deliver message to every room user", zap.String("room_id", roomName), zap.Error(err))
 		}
+
+		return nil
+
+	case *proto.RoomMethod_SendSdp:
+		return s.relaySdp(ctx, interactor, room, user, m.SendSdp.Sdp)
+
+	case *proto.RoomMethod_SendIceCandidate:
+		return s.relayCandidate(ctx, interactor, room, user, m.SendIceCandidate.Candidate)
+
+	default:
+		return errInvalidMethod
 	}
 }
 
 func (s *RoomsService) sendRoomUsers(ctx context.Context, interactor rooms.Interactor, roomID string) error {
-	roomUsers, err := interactor.GetRoomUsers(roomID)
+	roomUsers, err := interactor.GetRoomUsers(ctx, roomID)
 	if err != nil {
-		return status.Error(codes.Internal, "couldnt fetch room users")
+		return err
+	}
+
+	for _, method := range roomUsersMethods(roomUsers) {
+		if err := s.hub.Broadcast(roomID, method); err != nil {
+			s.logger.Warn(ctx, "couldnt deliver room users to every room user", zap.String("room_id", roomID), zap.Error(err))
+		}
 	}
 
+	return nil
+}
+
+// roomUsersMethods lists the users of a room. proto.User has no role, so
+// roles follow as a dispatcher event.
+func roomUsersMethods(roomUsers []rooms.User) []*proto.RoomMethod {
 	protoRoomUsers := make([]*proto.User, len(roomUsers))
+	roles := make([]roomUserJSON, len(roomUsers))
 	for i, u := range roomUsers {
-		user := proto.User{Id: u.Id.String(), Username: u.Name}
-		protoRoomUsers[i] = &user
+		protoRoomUsers[i] = &proto.User{Id: u.Id.String(), Username: u.Name}
+		roles[i] = roomUserJSON{Id: u.Id.String(), Username: u.Name, Role: string(u.Role)}
 	}
 
 	method := &proto.RoomMethod{
@@ -316,20 +537,222 @@ func (s *RoomsService) sendRoomUsers(ctx context.Context, interactor rooms.Inter
 		},
 	}
 
-	for streamUser, stream := range s.Users {
-		if slices.Contains(roomUsers, streamUser) {
-			userStream, ok := stream.(proto.RoomsService_JoinRoomServer)
-			if !ok {
-				s.logger.Error(ctx, "couldnt convert stream to JoinRoom server stream")
-				return fmt.Errorf("couldnt process all room users")
+	return []*proto.RoomMethod{method, dispatcherMethod(dispatcherEvent{Type: "room_users", Data: roles})}
+}
+
+type roomUserJSON struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+}
+
+type received struct {
+	msg *proto.RoomMethod
+	err error
+}
+
+// receive pumps stream.Recv into a channel so the JoinRoom loop can also
+// react to its client being closed by the hub.
+func receive(ctx context.Context, stream proto.RoomsService_JoinRoomServer) <-chan received {
+	ch := make(chan received)
+
+	go func() {
+		for {
+			msg, err := stream.Recv()
+
+			select {
+			case ch <- received{msg: msg, err: err}:
+			case <-ctx.Done():
+				return
 			}
 
-			err = userStream.Send(method)
 			if err != nil {
-				return fmt.Errorf("couldnt send room users")
+				return
 			}
 		}
+	}()
+
+	return ch
+}
+
+func clientClosedStatus(err error) error {
+	if err == nil {
+		return statusWithReason(codes.Aborted, "CLOSED_BY_SERVER", "connection closed by server")
 	}
 
+	return toStatus(err)
+}
+
+// closeRoom closes a room with remove, then disconnects everyone still
+// streaming in it or waiting in its lobby.
+func (s *RoomsService) closeRoom(ctx context.Context, id string, remove func(context.Context, string) error) error {
+	if err := remove(ctx, id); err != nil {
+		return err
+	}
+
+	s.hub.CloseRoom(id, rooms.ErrRoomClosed)
+	s.lobby.closeRoom(id, rooms.ErrRoomClosed)
+	s.candidates.closeRoom(id)
+
 	return nil
 }
+
+// CollectEmptyRooms closes rooms that stayed empty for longer than ttl,
+// checking every interval until ctx is done.
+func (s *RoomsService) CollectEmptyRooms(ctx context.Context, ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	closeEmpty := func(ctx context.Context, id string) error {
+		return s.interactor().CloseEmptyRoom(ctx, id, ttl)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			empty, err := s.interactor().EmptyRooms(ctx, ttl)
+			if err != nil {
+				s.logger.Error(ctx, "couldnt list empty rooms", zap.Error(err))
+			}
+
+			for _, id := range empty {
+				err := s.closeRoom(ctx, id, closeEmpty)
+				// The room filled again or was closed meanwhile.
+				if errors.Is(err, rooms.ErrRoomNotEmpty) || errors.Is(err, rooms.ErrRoomNotFound) {
+					continue
+				}
+				if err != nil {
+					s.logger.Error(ctx, "couldnt close empty room", zap.String("room_id", id), zap.Error(err))
+					continue
+				}
+
+				s.logger.Info(ctx, "closed empty room", zap.String("room_id", id))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// userFromContext builds the user making a call from its verified JWT
+// identity, or from the Username header when authentication is disabled.
+// Authenticated users keep the same id across connections.
+func userFromContext(ctx context.Context) (rooms.User, error) {
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		if reservedUsername(identity.Username) {
+			return rooms.User{}, errReservedUsername
+		}
+
+		id, err := uuid.Parse(identity.Subject)
+		if err != nil {
+			id = uuid.NewSHA1(uuid.NameSpaceURL, []byte(identity.Subject))
+		}
+
+		return rooms.User{Id: id, Name: identity.Username, Subject: identity.Subject}, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	usernames := md.Get(usernameMetadata)
+	if len(usernames) == 0 {
+		return rooms.User{}, invalidArgument("INVALID_METADATA", "couldnt extract username from request")
+	}
+	if reservedUsername(usernames[0]) {
+		return rooms.User{}, errReservedUsername
+	}
+
+	return rooms.User{Id: uuid.New(), Name: usernames[0]}, nil
+}
+
+func roomSettingsFromMetadata(md metadata.MD) (rooms.Settings, error) {
+	settings := rooms.Settings{}
+
+	if values := md.Get(overflowModeMetadata); len(values) > 0 {
+		settings.Overflow = rooms.OverflowMode(values[0])
+		if !settings.Overflow.Valid() {
+			return settings, invalidArgument("INVALID_METADATA", "overflow mode must be reject or viewers")
+		}
+	}
+
+	if values := md.Get(candidatePolicyMetadata); len(values) > 0 {
+		settings.CandidatePolicy = signaling.CandidatePolicy(values[0])
+		if !settings.CandidatePolicy.Valid() {
+			return settings, invalidArgument("INVALID_METADATA", "candidate policy must be all, no-host or relay")
+		}
+	}
+
+	return settings, nil
+}
+
+// authorize asks the authorizer whether user may perform action in a room.
+func (s *RoomsService) authorize(ctx context.Context, user rooms.User, action rooms.Action, roomID, roomName string) error {
+	principal := rooms.Principal{Identity: user.Identity(), Username: user.Name}
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		principal.Groups = identity.Groups
+	}
+
+	return s.authorizer.Authorize(ctx, rooms.AccessRequest{
+		Principal: principal,
+		Action:    action,
+		RoomID:    roomID,
+		RoomName:  roomName,
+	})
+}
+
+// methodAction maps a room method to the action it performs. Answering
+// offers only receives media, so it merely signals.
+func methodAction(msg *proto.RoomMethod) (rooms.Action, bool) {
+	switch m := msg.Method.(type) {
+	case *proto.RoomMethod_SendMessage:
+		if cmd, ok := parseCommand(m.SendMessage.Text); ok {
+			return cmd.action(), true
+		}
+		return rooms.ActionChat, true
+
+	case *proto.RoomMethod_SendSdp:
+		for _, sdp := range m.SendSdp.Sdp {
+			if sdp.Type != "answer" {
+				return rooms.ActionPublish, true
+			}
+		}
+		return rooms.ActionSignal, true
+
+	case *proto.RoomMethod_SendIceCandidate:
+		return rooms.ActionSignal, true
+
+	default:
+		return "", false
+	}
+}
+
+// authorizeMethod checks whether a user may send a room method. Users
+// without the publish permission may still answer offers to receive media.
+func authorizeMethod(user rooms.User, msg *proto.RoomMethod) error {
+	switch m := msg.Method.(type) {
+	case *proto.RoomMethod_SendSdp:
+		if user.Role.Can(rooms.PermissionPublish) {
+			return nil
+		}
+
+		for _, sdp := range m.SendSdp.Sdp {
+			if sdp.Type != "answer" {
+				return statusWithReason(codes.PermissionDenied, "FORBIDDEN", string(user.Role)+"s may only send sdp answers")
+			}
+		}
+		return nil
+
+	case *proto.RoomMethod_SendMessage:
+		if _, ok := parseCommand(m.SendMessage.Text); ok {
+			// Commands check their own permissions.
+			return nil
+		}
+
+		if !user.Role.Can(rooms.PermissionChat) {
+			return statusWithReason(codes.PermissionDenied, "FORBIDDEN", string(user.Role)+"s cannot send messages")
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
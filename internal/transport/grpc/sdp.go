@@ -0,0 +1,89 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// targetError is the failure to deliver a method to one of its recipients.
+// The sender gets a separate error for each of them.
+type targetError struct {
+	target string
+	err    error
+}
+
+func (e targetError) Error() string {
+	return fmt.Sprintf("%s: %v", e.target, e.err)
+}
+
+func (e targetError) Unwrap() error {
+	return e.err
+}
+
+// relaySdp sends each SDP to the user whose id is in its username field,
+// once it complies with the SDP policy and the room's candidate policy.
+// SdpReceived carries the user ids of both the recipient and the sender.
+// Failing targets do not keep the others from getting theirs.
+func (s *RoomsService) relaySdp(ctx context.Context, interactor rooms.Interactor, room rooms.Room, user rooms.User, sdps []*proto.SDP) error {
+	roomUsers, err := interactor.GetRoomUsers(ctx, room.Id)
+	if err != nil {
+		return err
+	}
+
+	policy := s.sdpPolicy
+	policy.Candidates = room.Settings.CandidatePolicy
+
+	var errs []error
+	for _, sdp := range sdps {
+		if err := s.sendSdp(room.Id, roomUsers, policy, user, sdp); err != nil {
+			s.logger.Warn(ctx, "couldnt send sdp", zap.String("room_id", room.Id), zap.String("to", sdp.Username), zap.Error(err))
+			errs = append(errs, targetError{target: sdp.Username, err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *RoomsService) sendSdp(roomID string, roomUsers []rooms.User, policy signaling.Policy, user rooms.User, sdp *proto.SDP) error {
+	to, err := uuid.Parse(sdp.Username)
+	if err != nil {
+		return invalidArgument("INVALID_TARGET", "sdp username must be the user id of its recipient")
+	}
+	if to == user.Id {
+		return invalidArgument("INVALID_TARGET", "cannot send an sdp to yourself")
+	}
+
+	found := false
+	for _, u := range roomUsers {
+		if u.Id == to {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return signaling.ErrUnknownTarget
+	}
+
+	relayed, err := policy.Enforce(sdp.Type, sdp.Sdp)
+	if err != nil {
+		return err
+	}
+
+	return s.hub.SendTo(roomID, to, &proto.RoomMethod{
+		Method: &proto.RoomMethod_SdpReceived{
+			SdpReceived: &proto.SDPReceivedNotification{
+				Type: sdp.Type,
+				Sdp:  relayed,
+				To:   to.String(),
+				From: user.Id.String(),
+			},
+		},
+	})
+}
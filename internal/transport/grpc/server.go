@@ -2,13 +2,24 @@ package grpc
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
 	"sync"
 
+	"github.com/gitgernit/videochat-rooms/internal/config"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
 	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/signaling/turn"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
 	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/rs/cors"
 	"github.com/tmc/grpc-websocket-proxy/wsproxy"
@@ -23,28 +34,130 @@ type Server struct {
 	grpcServer   *grpc.Server
 	grpcListener net.Listener
 	gwServer     *http.Server
+	// metricsServer is nil when metrics are disabled.
+	metricsServer *http.Server
+	roomsService  *RoomsService
+	policy        *auth.PolicyAuthorizer
+	cfg           *config.Config
+	stopped       chan struct{}
 }
 
 func NewServer(
 	ctx context.Context,
 	logger logger.Logger,
-	incomingRoomsChannel chan string,
-	grpcHost,
-	gwHost string,
-	grpcPort,
-	gwPort int,
+	cfg *config.Config,
 ) (*Server, error) {
-	grpcLis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", grpcHost, grpcPort))
+	grpcLis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.GRPCServerHost, cfg.GRPCServerPort))
 	if err != nil {
 		return nil, err
 	}
 
-	var opts []grpc.ServerOption
+	unaryInterceptors := []grpc.UnaryServerInterceptor{RequestIDUnaryInterceptor}
+	streamInterceptors := []grpc.StreamServerInterceptor{RequestIDStreamInterceptor}
+
+	var verifier *auth.Verifier
+	if cfg.AuthRequired {
+		verifier, err = auth.NewVerifier(auth.Options{
+			HMACSecret:    []byte(cfg.JWTHS256Secret),
+			JWKSFile:      cfg.JWTJWKSFile,
+			Issuer:        cfg.JWTIssuer,
+			Audience:      cfg.JWTAudience,
+			UsernameClaim: cfg.JWTUsernameClaim,
+			GroupsClaim:   cfg.JWTGroupsClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		unaryInterceptors = append(unaryInterceptors, AuthUnaryInterceptor(verifier))
+		streamInterceptors = append(streamInterceptors, AuthStreamInterceptor(verifier))
+	} else {
+		logger.Warn(ctx, "authentication disabled, usernames are not verified; set AUTH_REQUIRED=true to require JWTs")
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	policy, err := hub.ParseSlowConsumerPolicy(cfg.OutboxSlowConsumerPolicy)
+	if err != nil {
+		return nil, err
+	}
 
 	repository := memory.NewRepository()
+	roomsHub := hub.New(hub.Options{
+		Shards:       hub.DefaultShards,
+		QueueDepth:   cfg.OutboxQueueDepth,
+		Policy:       policy,
+		ReplayBuffer: cfg.RoomReplayBuffer,
+	})
+	publishHubMetrics(roomsHub)
+	events := pubsub.New[rooms.Event](cfg.EventsSubscriberBuffer)
+
+	overflow := rooms.OverflowMode(cfg.DefaultOverflowMode)
+	if !overflow.Valid() {
+		return nil, fmt.Errorf("invalid default overflow mode: %s", cfg.DefaultOverflowMode)
+	}
+
+	candidatePolicy := signaling.CandidatePolicy(cfg.DefaultCandidatePolicy)
+	if !candidatePolicy.Valid() {
+		return nil, fmt.Errorf("invalid default candidate policy: %s", cfg.DefaultCandidatePolicy)
+	}
+
+	inviteSecret := []byte(cfg.InviteSecret)
+	if len(inviteSecret) == 0 {
+		logger.Warn(ctx, "no invite secret configured, invites will not survive a restart")
+		inviteSecret = make([]byte, 32)
+		if _, err := rand.Read(inviteSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	var turnIssuer signaling.TURNIssuer
+	if cfg.TURNSecret != "" {
+		if cfg.TURNCredentialTTL <= 0 {
+			return nil, fmt.Errorf("invalid turn credential ttl: %s", cfg.TURNCredentialTTL)
+		}
+		turnIssuer = turn.NewREST([]byte(cfg.TURNSecret), cfg.TURNCredentialTTL)
+	} else if len(cfg.TURNURLs) > 0 {
+		logger.Warn(ctx, "no turn secret configured, turn servers will not be handed out")
+	}
+
+	var authorizer rooms.Authorizer = auth.AllowAll{}
+	var policyAuthorizer *auth.PolicyAuthorizer
+	if cfg.AuthPolicyFile != "" {
+		policyAuthorizer, err = auth.NewPolicyAuthorizer(cfg.AuthPolicyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		authorizer = policyAuthorizer
+	}
+
+	roomsService := NewRoomsService(logger, repository, events, invites.NewHMAC(inviteSecret), authorizer, roomsHub, SessionOptions{
+		GracePeriod:  cfg.SessionGracePeriod,
+		ReplayBuffer: cfg.SessionReplayBuffer,
+	}, SignalingOptions{
+		CandidateBuffer: cfg.ICECandidateBuffer,
+		CandidateTTL:    cfg.ICECandidateTTL,
+		SDPPolicy: signaling.Policy{
+			Codecs:               cfg.SDPCodecs,
+			MaxBitrate:           cfg.SDPMaxBitrate,
+			DisallowedExtensions: cfg.SDPDisallowedExtensions,
+			MaxMediaSections:     cfg.SDPMaxMediaSections,
+		},
+		STUNURLs: cfg.STUNURLs,
+		TURNURLs: cfg.TURNURLs,
+		TURN:     turnIssuer,
+	}, rooms.Config{
+		DefaultMaxParticipants: cfg.DefaultMaxParticipants,
+		DefaultOverflow:        overflow,
+		DefaultInviteTTL:       cfg.DefaultInviteTTL,
+		DefaultCandidatePolicy: candidatePolicy,
+	})
 
 	grpcServer := grpc.NewServer(opts...)
-	proto.RegisterRoomsServiceServer(grpcServer, NewRoomsService(logger, repository, incomingRoomsChannel))
+	proto.RegisterRoomsServiceServer(grpcServer, roomsService)
 
 	gwMux := runtime.NewServeMux(
 		runtime.WithIncomingHeaderMatcher(RoomsHeaderMatcher),
@@ -60,7 +173,7 @@ func NewServer(
 	)
 
 	conn, err := grpc.NewClient(
-		fmt.Sprintf("%s:%d", grpcHost, grpcPort),
+		fmt.Sprintf("%s:%d", cfg.GRPCServerHost, cfg.GRPCServerPort),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
 	if err != nil {
@@ -69,28 +182,85 @@ func NewServer(
 	if err := proto.RegisterRoomsServiceHandler(ctx, gwMux, conn); err != nil {
 		return nil, err
 	}
+	if err := RegisterGatewayRoutes(gwMux, roomsService, verifier); err != nil {
+		return nil, err
+	}
 
 	corsMux := cors.New(cors.Options{
-		AllowOriginFunc:  func(origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"ACCEPT", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowOriginFunc: func(origin string) bool { return true },
+		AllowedMethods:  []string{"GET", "POST", "PATCH", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{
+			"ACCEPT", "Authorization", "Content-Type", "X-CSRF-Token",
+			"X-Request-Id", "Idempotency-Key", "Max-Participants", "Overflow-Mode", "Room-Private", "Room-Password", "Room-Lobby", "Invite", "Session-Token", "Sequenced", "Candidate-Policy",
+		},
+		ExposedHeaders:   []string{"Link", "Grpc-Metadata-Room-Id", "Grpc-Metadata-Session-Token", "Grpc-Metadata-Room-Seq", "Grpc-Metadata-Candidate-Policy"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}).Handler(wsMux)
 
 	gwServer := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", gwHost, gwPort),
+		Addr:    fmt.Sprintf("%s:%d", cfg.RESTServerHost, cfg.RESTServerPort),
 		Handler: corsMux,
 	}
 
-	return &Server{grpcServer, grpcLis, gwServer}, nil
+	// Metrics are served on their own listener, which is not meant to be
+	// exposed, rather than next to the public routes.
+	var metricsServer *http.Server
+	if cfg.MetricsServerPort != 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /debug/vars", expvar.Handler())
+		metricsServer = &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", cfg.MetricsServerHost, cfg.MetricsServerPort),
+			Handler: metricsMux,
+		}
+	}
+
+	return &Server{
+		grpcServer:    grpcServer,
+		grpcListener:  grpcLis,
+		gwServer:      gwServer,
+		metricsServer: metricsServer,
+		roomsService:  roomsService,
+		policy:        policyAuthorizer,
+		cfg:           cfg,
+		stopped:       make(chan struct{}),
+	}, nil
+}
+
+// publishHubMetrics exposes hub counters under /debug/vars. expvar panics on
+// duplicate names, so only the first hub is published.
+func publishHubMetrics(h *hub.Hub) {
+	if expvar.Get("rooms_hub") != nil {
+		return
+	}
+
+	expvar.Publish("rooms_hub", expvar.Func(func() any {
+		return h.Metrics()
+	}))
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	l := logger.GetLoggerFromCtx(ctx)
 	eg := errgroup.Group{}
 
+	backgroundCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-s.stopped
+		cancel()
+	}()
+
+	if s.cfg.EmptyRoomTTL > 0 {
+		go s.roomsService.CollectEmptyRooms(backgroundCtx, s.cfg.EmptyRoomTTL, s.cfg.EmptyRoomScanInterval)
+	}
+
+	if s.cfg.ICECandidateBuffer > 0 && s.cfg.ICECandidateTTL > 0 {
+		go s.roomsService.ExpireCandidates(backgroundCtx, s.cfg.ICECandidateTTL)
+	}
+
+	if s.policy != nil {
+		go s.policy.Watch(backgroundCtx, s.cfg.AuthPolicyReloadInterval)
+	}
+
 	eg.Go(func() error {
 		l.Info(ctx, "grpc: server start")
 		return s.grpcServer.Serve(s.grpcListener)
@@ -101,12 +271,21 @@ func (s *Server) Start(ctx context.Context) error {
 		return s.gwServer.ListenAndServe()
 	})
 
+	if s.metricsServer != nil {
+		eg.Go(func() error {
+			l.Info(ctx, "metrics: server start")
+			return s.metricsServer.ListenAndServe()
+		})
+	}
+
 	return eg.Wait()
 }
 
 func (s *Server) Stop(ctx context.Context) error {
 	l := logger.GetLoggerFromCtx(ctx)
-	var err error
+	var err, metricsErr error
+	close(s.stopped)
+
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
@@ -122,6 +301,15 @@ func (s *Server) Stop(ctx context.Context) error {
 		l.Info(ctx, "gateway: server stopped")
 	}()
 
+	if s.metricsServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metricsErr = s.metricsServer.Shutdown(ctx)
+			l.Info(ctx, "metrics: server stopped")
+		}()
+	}
+
 	wg.Wait()
-	return err
+	return errors.Join(err, metricsErr)
 }
@@ -0,0 +1,334 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+var errSessionNotFound = errors.New("no such session")
+
+// SessionOptions configure resumption of dropped JoinRoom streams. A zero
+// GracePeriod disables it, so users leave as soon as their stream ends.
+type SessionOptions struct {
+	GracePeriod time.Duration
+	// ReplayBuffer is how many messages are kept for a detached user, the
+	// oldest are dropped first.
+	ReplayBuffer int
+}
+
+// session is a user's membership in a room, which outlives a dropped
+// stream for the grace period. While detached, the user's hub client
+// writes into missed instead of a stream.
+type session struct {
+	token  string
+	roomID string
+	user   rooms.User
+	client *hub.Client
+
+	missed *missedBuffer
+	timer  *time.Timer
+}
+
+func (s *session) detached() bool {
+	return s.missed != nil
+}
+
+type sessions struct {
+	mu      sync.Mutex
+	byToken map[string]*session
+}
+
+func newSessions() *sessions {
+	return &sessions{byToken: make(map[string]*session)}
+}
+
+func newSessionToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (s *sessions) start(token string, roomID string, user rooms.User, client *hub.Client) *session {
+	sess := &session{
+		token:  token,
+		roomID: roomID,
+		user:   user,
+		client: client,
+	}
+
+	s.mu.Lock()
+	s.byToken[sess.token] = sess
+	s.mu.Unlock()
+
+	return sess
+}
+
+// missedBuffer is the hub stream of a detached user.
+type missedBuffer struct {
+	mu      sync.Mutex
+	limit   int
+	msgs    []hub.Message
+	dropped int
+}
+
+func (b *missedBuffer) Send(msg *proto.RoomMethod) error {
+	return b.SendSequenced(hub.Message{Method: msg})
+}
+
+func (b *missedBuffer) SendSequenced(msg hub.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.msgs) >= b.limit {
+		b.msgs = b.msgs[1:]
+		b.dropped++
+	}
+	b.msgs = append(b.msgs, msg)
+
+	return nil
+}
+
+func (b *missedBuffer) drain() ([]hub.Message, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msgs, dropped := b.msgs, b.dropped
+	b.msgs, b.dropped = nil, 0
+
+	return msgs, dropped
+}
+
+// endSession runs once client's stream is over. A resumable end detaches
+// the session for the grace period, anything else leaves the room.
+func (s *RoomsService) endSession(ctx context.Context, interactor rooms.Interactor, sess *session, client *hub.Client, resumable bool) {
+	if resumable && s.sessionOptions.GracePeriod > 0 {
+		// The stream is over, so this does not block for long. Messages
+		// not written yet then move into the missed buffer.
+		client.Pause()
+	}
+
+	s.sessions.mu.Lock()
+
+	// Another stream resumed the session and now owns it.
+	if sess.client != client {
+		s.sessions.mu.Unlock()
+		return
+	}
+
+	if resumable && s.sessionOptions.GracePeriod > 0 {
+		missed := &missedBuffer{limit: max(s.sessionOptions.ReplayBuffer, 1)}
+
+		parked, err := s.hub.Reattach(client, missed, nil)
+		if err == nil {
+			sess.client = parked
+			sess.missed = missed
+			sess.timer = time.AfterFunc(s.sessionOptions.GracePeriod, func() {
+				s.expireSession(sess)
+			})
+			s.sessions.mu.Unlock()
+
+			s.logger.Info(ctx, "session detached", zap.String("room_id", sess.roomID), zap.String("username", sess.user.Name))
+			return
+		}
+	}
+
+	delete(s.sessions.byToken, sess.token)
+	s.sessions.mu.Unlock()
+
+	s.leave(ctx, interactor, sess.roomID, sess.user, sess.client)
+}
+
+// expireSession leaves the room for a user who did not come back within
+// the grace period.
+func (s *RoomsService) expireSession(sess *session) {
+	s.sessions.mu.Lock()
+	if s.sessions.byToken[sess.token] != sess || !sess.detached() {
+		s.sessions.mu.Unlock()
+		return
+	}
+	delete(s.sessions.byToken, sess.token)
+	s.sessions.mu.Unlock()
+
+	ctx := context.Background()
+	s.logger.Info(ctx, "session expired", zap.String("room_id", sess.roomID), zap.String("username", sess.user.Name))
+	s.leave(ctx, s.interactor(), sess.roomID, sess.user, sess.client)
+}
+
+// endDetached leaves the room for detached sessions of identity, so the
+// user can join afresh without their session token rather than find their
+// username taken until the grace period is over.
+func (s *RoomsService) endDetached(ctx context.Context, interactor rooms.Interactor, roomID string, identity string) {
+	var ended []*session
+
+	s.sessions.mu.Lock()
+	for token, sess := range s.sessions.byToken {
+		if sess.roomID == roomID && sess.detached() && sess.user.Identity() == identity {
+			sess.timer.Stop()
+			delete(s.sessions.byToken, token)
+			ended = append(ended, sess)
+		}
+	}
+	s.sessions.mu.Unlock()
+
+	for _, sess := range ended {
+		s.logger.Info(ctx, "session replaced by a new join", zap.String("room_id", sess.roomID), zap.String("username", sess.user.Name))
+		s.leave(ctx, interactor, sess.roomID, sess.user, sess.client)
+	}
+}
+
+// leave takes a user out of the room and tells everyone left.
+func (s *RoomsService) leave(ctx context.Context, interactor rooms.Interactor, roomID string, user rooms.User, client *hub.Client) {
+	s.hub.Unregister(client)
+
+	err := interactor.LeaveRoom(ctx, roomID, user)
+	// Kicked users are already gone from the room.
+	if err != nil && !errors.Is(err, rooms.ErrRoomNotFound) && !errors.Is(err, rooms.ErrUserNotInRoom) {
+		s.logger.Error(ctx, err.Error(), zap.String("room_id", roomID), zap.String("username", user.Name))
+	}
+
+	err = s.sendRoomUsers(ctx, interactor, roomID)
+	if err != nil && !errors.Is(err, rooms.ErrRoomNotFound) {
+		s.logger.Error(ctx, "couldnt send room users upon user leaving room")
+	}
+}
+
+// resumeSession reattaches stream to the session with token, replaying what
+// the user missed while detached. A session still attached to another
+// stream is taken over, since the old stream is most likely dead already.
+func (s *RoomsService) resumeSession(stream proto.RoomsService_JoinRoomServer, interactor rooms.Interactor, token string) error {
+	ctx := stream.Context()
+
+	caller, err := userFromContext(ctx)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	s.sessions.mu.Lock()
+	sess, ok := s.sessions.byToken[token]
+	s.sessions.mu.Unlock()
+
+	if !ok || sess.user.Identity() != caller.Identity() {
+		return toStatus(errSessionNotFound)
+	}
+
+	user, err := interactor.GetRoomUser(ctx, sess.roomID, sess.user.Id)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	room, err := interactor.GetRoom(ctx, sess.roomID)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	outbound, err := outboundStream(stream)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sendHeader(stream, token, room, user); err != nil {
+		return err
+	}
+
+	s.sessions.mu.Lock()
+	if s.sessions.byToken[token] != sess {
+		s.sessions.mu.Unlock()
+		return toStatus(errSessionNotFound)
+	}
+
+	var backlog []hub.Message
+	dropped := 0
+	if sess.detached() {
+		sess.timer.Stop()
+		// Writing into the missed buffer never blocks.
+		sess.client.Pause()
+		backlog, dropped = sess.missed.drain()
+	}
+
+	client, err := s.hub.Reattach(sess.client, outbound, backlog)
+	if err != nil {
+		delete(s.sessions.byToken, token)
+		s.sessions.mu.Unlock()
+		return toStatus(errSessionNotFound)
+	}
+
+	sess.client = client
+	sess.missed = nil
+	sess.timer = nil
+	s.sessions.mu.Unlock()
+
+	s.logger.Info(ctx, "session resumed", zap.String("room_id", sess.roomID), zap.String("username", user.Name), zap.Int("missed", len(backlog)))
+
+	resumable, err := s.attach(ctx, stream, interactor, client, room, user, func() error {
+		event := dispatcherEvent{Type: "resumed", Data: map[string]int{"missed": len(backlog), "dropped": dropped}}
+		if err := client.Send(dispatcherMethod(event)); err != nil {
+			return err
+		}
+
+		// Only the resumed user needs the current list, nobody else saw
+		// a change.
+		users, err := interactor.GetRoomUsers(ctx, sess.roomID)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range roomUsersMethods(users) {
+			if err := client.Send(msg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	s.endSession(context.WithoutCancel(ctx), interactor, sess, client, resumable)
+
+	return err
+}
+
+// sendHeader sends the response headers of a joined or resumed stream. It
+// must happen before the stream is registered in the hub, since the first
+// message sent also sends empty headers. Room messages sent meanwhile
+// follow room-seq and can be replayed.
+func (s *RoomsService) sendHeader(stream proto.RoomsService_JoinRoomServer, token string, room rooms.Room, user rooms.User) error {
+	header := metadata.Pairs(
+		roomIDMetadata, room.Id,
+		userIDMetadata, user.Id.String(),
+		roleMetadata, string(user.Role),
+		roomSeqMetadata, strconv.FormatUint(s.hub.Seq(room.Id, user.Id), 10),
+		candidatePolicyMetadata, string(room.Settings.CandidatePolicy),
+	)
+	if s.sessionOptions.GracePeriod > 0 {
+		header.Set(sessionTokenMetadata, token)
+	}
+
+	if err := stream.SendHeader(header); err != nil {
+		return toStatus(err)
+	}
+
+	return nil
+}
+
+// attach sends the initial messages of a joined or resumed stream, then
+// serves its room methods.
+func (s *RoomsService) attach(ctx context.Context, stream proto.RoomsService_JoinRoomServer, interactor rooms.Interactor, client *hub.Client, room rooms.Room, user rooms.User, greet func() error) (bool, error) {
+	if err := greet(); err != nil {
+		return false, toStatus(err)
+	}
+
+	return s.serve(ctx, stream, interactor, client, room, user)
+}
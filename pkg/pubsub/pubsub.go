@@ -0,0 +1,139 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultBufferSize = 64
+
+var (
+	ErrSlowSubscriber = errors.New("subscriber dropped for not keeping up with events")
+	ErrBrokerClosed   = errors.New("broker closed")
+)
+
+// Broker fans every published value out to every subscriber. Publish never
+// blocks: a subscriber whose buffer is full is dropped and learns about it
+// through Err, so it can resubscribe and resynchronise.
+type Broker[T any] struct {
+	bufferSize int
+
+	mu          sync.RWMutex
+	subscribers map[uint64]*Subscription[T]
+	nextID      atomic.Uint64
+	closed      bool
+}
+
+func New[T any](bufferSize int) *Broker[T] {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Broker[T]{
+		bufferSize:  bufferSize,
+		subscribers: make(map[uint64]*Subscription[T]),
+	}
+}
+
+func (b *Broker[T]) Subscribe() *Subscription[T] {
+	sub := &Subscription[T]{
+		id:     b.nextID.Add(1),
+		broker: b,
+		ch:     make(chan T, b.bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.close(ErrBrokerClosed)
+		return sub
+	}
+
+	b.subscribers[sub.id] = sub
+	return sub
+}
+
+func (b *Broker[T]) Publish(value T) {
+	var slow []*Subscription[T]
+
+	b.mu.RLock()
+	for _, sub := range b.subscribers {
+		select {
+		case sub.ch <- value:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range slow {
+		b.remove(sub, ErrSlowSubscriber)
+	}
+}
+
+// Close drops every subscriber. Later subscriptions are closed immediately.
+func (b *Broker[T]) Close() {
+	b.mu.Lock()
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = make(map[uint64]*Subscription[T])
+	b.mu.Unlock()
+
+	for _, sub := range subscribers {
+		sub.close(ErrBrokerClosed)
+	}
+}
+
+func (b *Broker[T]) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subscribers)
+}
+
+func (b *Broker[T]) remove(sub *Subscription[T], reason error) {
+	b.mu.Lock()
+	delete(b.subscribers, sub.id)
+	b.mu.Unlock()
+
+	sub.close(reason)
+}
+
+type Subscription[T any] struct {
+	id     uint64
+	broker *Broker[T]
+	ch     chan T
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// C delivers published values. It is never closed; select on Done as well.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+func (s *Subscription[T]) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, nil if it was closed by its owner.
+func (s *Subscription[T]) Err() error {
+	<-s.done
+	return s.err
+}
+
+func (s *Subscription[T]) Close() {
+	s.broker.remove(s, nil)
+}
+
+func (s *Subscription[T]) close(reason error) {
+	s.closeOnce.Do(func() {
+		s.err = reason
+		close(s.done)
+	})
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+func signHS256(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testJWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestVerifierHS256(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Options{HMACSecret: testJWTSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signHS256(t, jwt.MapClaims{
+		"sub":                "user-1",
+		"preferred_username": "alice",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	})
+
+	identity, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-1" || identity.Username != "alice" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	expired := signHS256(t, jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()})
+	if _, err := verifier.Verify(expired); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(forged); err == nil {
+		t.Fatal("expected a token signed with another secret to be rejected")
+	}
+}
+
+func TestVerifierES256FromJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "key-1",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := auth.NewVerifier(auth.Options{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "key-1"
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "user-1" {
+		t.Fatalf("expected the subject as username, got %q", identity.Username)
+	}
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Options{HMACSecret: testJWTSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := transport.AuthUnaryInterceptor(verifier)
+	handler := func(ctx context.Context, _ any) (any, error) {
+		identity, _ := auth.IdentityFromContext(ctx)
+		return identity, nil
+	}
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	token := signHS256(t, jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+
+	resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(auth.Identity).Subject != "user-1" {
+		t.Fatalf("unexpected identity %+v", resp)
+	}
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCloseRoomEndsStreams(t *testing.T) {
+	ctx := context.Background()
+	service, _, client := newSessionTestServer(t)
+
+	mux := runtime.NewServeMux()
+	if err := transport.RegisterGatewayRoutes(mux, service, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	if _, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice"), &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, ctx, client, "username", "alice", "room_name", "room")
+	bob, _ := joinRoom(t, ctx, client, "username", "bob", "room_name", "room")
+
+	if resp := gatewayRequest(t, http.MethodDelete, server.URL+"/rooms/room", "alice", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the owner to close the room, got %s", resp.Status)
+	}
+
+	for name, stream := range map[string]proto.RoomsService_JoinRoomClient{"alice": alice, "bob": bob} {
+		if code, reason := streamEnd(t, stream); code != codes.Aborted || reason != "ROOM_CLOSED" {
+			t.Fatalf("expected %s's stream to end with the room, got %s %s", name, code, reason)
+		}
+	}
+}
+
+func TestCollectEmptyRoomsEndsLobbyStreams(t *testing.T) {
+	ctx := context.Background()
+	service, _, client := newSessionTestServer(t)
+
+	ownerCtx := metadata.AppendToOutgoingContext(ctx, "username", "alice", "room-lobby", "true")
+	if _, err := client.CreateRoom(ownerCtx, &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, ctx, client, "username", "alice", "room_name", "room")
+
+	bob, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, "username", "bob", "room_name", "room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, ok := dispatched(msg)
+		return ok && event.Type == "join_request"
+	})
+
+	// Bob still waits in the lobby once Alice has left and the room is empty.
+	sendText(t, alice, "/leave")
+	for {
+		if _, err := alice.Recv(); err != nil {
+			break
+		}
+	}
+
+	collectCtx, stopCollecting := context.WithCancel(ctx)
+	t.Cleanup(stopCollecting)
+	go service.CollectEmptyRooms(collectCtx, time.Millisecond, 10*time.Millisecond)
+
+	if code, reason := streamEnd(t, bob); code != codes.Aborted || reason != "ROOM_CLOSED" {
+		t.Fatalf("expected bob's stream to end with the room, got %s %s", code, reason)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnknownCommandsAreChat(t *testing.T) {
+	service, _, client := newSessionTestServer(t)
+
+	room, err := service.CreateRoom(context.Background(), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, context.Background(), client, "username", "alice", "room_name", room.Name)
+
+	for _, text := range []string{"/shrug", "/r/golang is down"} {
+		sendText(t, alice, text)
+
+		msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+			if event, ok := dispatched(msg); ok && event.Type == "error" {
+				t.Fatalf("expected %q to be chat, got an error %s", text, event.Reason)
+			}
+			return msg.GetMessageReceived().GetUsername() == "alice"
+		})
+		if msg.GetMessageReceived().Text != text {
+			t.Fatalf("expected %q, got %q", text, msg.GetMessageReceived().Text)
+		}
+	}
+}
+
+func TestKickAndBanEndStreams(t *testing.T) {
+	ctx := context.Background()
+	_, _, client := newSessionTestServer(t)
+
+	if _, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice"), &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, ctx, client, "username", "alice", "room_name", "room")
+	bob, bobHeader := joinRoom(t, ctx, client, "username", "bob", "room_name", "room")
+	carol, carolHeader := joinRoom(t, ctx, client, "username", "carol", "room_name", "room")
+
+	sendText(t, alice, "/kick "+bobHeader.Get("user-id")[0])
+	if code, reason := streamEnd(t, bob); code != codes.Aborted || reason != "KICKED" {
+		t.Fatalf("expected bob to be kicked, got %s %s", code, reason)
+	}
+
+	sendText(t, alice, "/ban "+carolHeader.Get("user-id")[0])
+	if code, reason := streamEnd(t, carol); code != codes.PermissionDenied || reason != "BANNED" {
+		t.Fatalf("expected carol to be banned, got %s %s", code, reason)
+	}
+
+	rejoin, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, "username", "carol", "room_name", "room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, reason := streamEnd(t, rejoin); code != codes.PermissionDenied || reason != "BANNED" {
+		t.Fatalf("expected carol not to get back in, got %s %s", code, reason)
+	}
+}
+
+// streamEnd reads a stream until it ends and returns the code and reason
+// it ended with.
+func streamEnd(t *testing.T, stream proto.RoomsService_JoinRoomClient) (codes.Code, string) {
+	t.Helper()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return errorReason(t, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestJoinUnknownRoomReturnsNotFound(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+
+	client := proto.NewRoomsServiceClient(conn)
+	ctx = metadata.AppendToOutgoingContext(ctx, "username", "alice", "room_name", "missing")
+
+	stream, err := client.JoinRoom(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stream.Recv()
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("expected a grpc status, got %v", err)
+	}
+
+	if st.Code() != codes.NotFound {
+		t.Fatalf("expected NotFound, got %s", st.Code())
+	}
+
+	var reason string
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			reason = info.Reason
+		}
+	}
+
+	if reason != "ROOM_NOT_FOUND" {
+		t.Fatalf("expected ROOM_NOT_FOUND reason, got %q", reason)
+	}
+}
+
+// errorReason returns the code and ErrorInfo reason of a grpc error.
+func errorReason(t *testing.T, err error) (codes.Code, string) {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("expected a grpc status, got %v", err)
+	}
+
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return st.Code(), info.Reason
+		}
+	}
+
+	return st.Code(), ""
+}
+
+func TestReservedUsername(t *testing.T) {
+	ctx := context.Background()
+	_, _, client := newSessionTestServer(t)
+
+	_, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "Dispatcher"), &proto.CreateRoomRequest{Name: "forged"})
+	if code, reason := errorReason(t, err); code != codes.InvalidArgument || reason != "RESERVED_USERNAME" {
+		t.Fatalf("expected CreateRoom to refuse the dispatcher username, got %s %s", code, reason)
+	}
+
+	if _, err := client.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, "username", "dispatcher", "room_name", "room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if code, reason := errorReason(t, err); code != codes.InvalidArgument || reason != "RESERVED_USERNAME" {
+		t.Fatalf("expected JoinRoom to refuse the dispatcher username, got %s %s", code, reason)
+	}
+}
+
+func TestMalformedInputCarriesReason(t *testing.T) {
+	ctx := context.Background()
+	service, _, client := newSessionTestServer(t)
+
+	_, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "max-participants", "many"), &proto.CreateRoomRequest{Name: "room"})
+	if code, reason := errorReason(t, err); code != codes.InvalidArgument || reason != "INVALID_METADATA" {
+		t.Fatalf("expected INVALID_METADATA, got %s %s", code, reason)
+	}
+
+	stream, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if code, reason := errorReason(t, err); code != codes.InvalidArgument || reason != "INVALID_METADATA" {
+		t.Fatalf("expected INVALID_METADATA, got %s %s", code, reason)
+	}
+
+	room, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, ctx, client, "username", "alice", "room_name", room.Name)
+	sendText(t, alice, "/kick")
+
+	msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, ok := dispatched(msg)
+		return ok && event.Type == "error"
+	})
+	if event, _ := dispatched(msg); event.Reason != "INVALID_COMMAND" {
+		t.Fatalf("expected INVALID_COMMAND, got %+v", event)
+	}
+}
@@ -0,0 +1,422 @@
+package tests
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/google/uuid"
+)
+
+type countingStream struct {
+	sent atomic.Int64
+}
+
+func (s *countingStream) Send(*proto.RoomMethod) error {
+	s.sent.Add(1)
+	return nil
+}
+
+func TestHubConcurrentRegisterAndBroadcast(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 4})
+	msg := &proto.RoomMethod{}
+
+	const users = 50
+	streams := make([]*countingStream, users)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < users; i++ {
+		streams[i] = &countingStream{}
+
+		wg.Add(1)
+		go func(stream *countingStream) {
+			defer wg.Done()
+
+			_, err := h.Register("room", rooms.User{Id: uuid.New(), Name: "user"}, stream)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if err := h.Broadcast("room", msg); err != nil {
+				t.Error(err)
+			}
+		}(streams[i])
+	}
+	wg.Wait()
+
+	if got := len(h.Clients("room")); got != users {
+		t.Fatalf("expected %d clients, got %d", users, got)
+	}
+
+	for _, c := range h.Clients("room") {
+		h.Unregister(c)
+	}
+
+	if got := len(h.Clients("room")); got != 0 {
+		t.Fatalf("expected empty room after unregister, got %d clients", got)
+	}
+}
+
+func TestHubRejectsDuplicateUser(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 1})
+	user := rooms.User{Id: uuid.New(), Name: "user"}
+
+	if _, err := h.Register("room", user, &countingStream{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Register("room", user, &countingStream{}); err != hub.ErrAlreadyRegistered {
+		t.Fatalf("expected ErrAlreadyRegistered, got %v", err)
+	}
+}
+
+type blockedStream struct {
+	release chan struct{}
+}
+
+func (s *blockedStream) Send(*proto.RoomMethod) error {
+	<-s.release
+	return nil
+}
+
+func TestHubSlowConsumerDoesNotBlockRoom(t *testing.T) {
+	h := hub.New(hub.Options{QueueDepth: 1, Policy: hub.PolicyDisconnect})
+
+	stuck := &blockedStream{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	slow, err := h.Register("room", rooms.User{Id: uuid.New(), Name: "slow"}, stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fast := &countingStream{}
+	if _, err := h.Register("room", rooms.User{Id: uuid.New(), Name: "fast"}, fast); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = h.Broadcast("room", &proto.RoomMethod{})
+
+		deadline := time.Now().Add(time.Second)
+		for fast.sent.Load() != int64(i+1) {
+			if time.Now().After(deadline) {
+				t.Fatal("fast client was blocked by the slow one")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow client was not disconnected")
+	}
+
+	if !errors.Is(slow.Err(), hub.ErrSlowConsumer) {
+		t.Fatalf("expected ErrSlowConsumer, got %v", slow.Err())
+	}
+
+	if h.Metrics().Disconnected != 1 {
+		t.Fatalf("expected one disconnected client, got %d", h.Metrics().Disconnected)
+	}
+}
+
+type sequencedStream struct {
+	mu   sync.Mutex
+	msgs []hub.Message
+}
+
+func (s *sequencedStream) Send(msg *proto.RoomMethod) error {
+	return s.SendSequenced(hub.Message{Method: msg})
+}
+
+func (s *sequencedStream) SendSequenced(msg hub.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (s *sequencedStream) seqs() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seqs := make([]uint64, len(s.msgs))
+	for i, msg := range s.msgs {
+		seqs[i] = msg.Seq
+	}
+
+	return seqs
+}
+
+func waitForSeqs(t *testing.T, stream *sequencedStream, want []uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := stream.seqs()
+		if slices.Equal(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected sequence numbers %v, got %v", want, got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubSequenceAndReplay(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 1, ReplayBuffer: 3})
+	alice := rooms.User{Id: uuid.New(), Name: "alice"}
+	bob := rooms.User{Id: uuid.New(), Name: "bob"}
+
+	aliceStream := &sequencedStream{}
+	aliceClient, err := h.Register("room", alice, aliceStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobStream := &sequencedStream{}
+	if _, err := h.Register("room", bob, bobStream); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SendTo("room", bob.Id, &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := aliceClient.Send(&proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Direct sends are outside of the room sequence, and Bob's message
+	// leaves no hole in Alice's.
+	waitForSeqs(t, aliceStream, []uint64{1, 0, 2})
+	waitForSeqs(t, bobStream, []uint64{1, 2, 3})
+
+	if got := h.Seq("room", alice.Id); got != 2 {
+		t.Fatalf("expected alice's sequence 2, got %d", got)
+	}
+	if got := h.Seq("room", bob.Id); got != 3 {
+		t.Fatalf("expected bob's sequence 3, got %d", got)
+	}
+
+	// Alice only gets what was sent to her.
+	n, err := h.Replay(aliceClient, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", n)
+	}
+	waitForSeqs(t, aliceStream, []uint64{1, 0, 2, 1, 2})
+
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Replay(aliceClient, 0); !errors.Is(err, hub.ErrReplayGap) {
+		t.Fatalf("expected ErrReplayGap, got %v", err)
+	}
+}
+
+func TestHubUnicastLeavesNoGap(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 1})
+	alice := rooms.User{Id: uuid.New(), Name: "alice"}
+	bob := rooms.User{Id: uuid.New(), Name: "bob"}
+
+	aliceStream := &sequencedStream{}
+	if _, err := h.Register("room", alice, aliceStream); err != nil {
+		t.Fatal(err)
+	}
+	bobStream := &sequencedStream{}
+	bobClient, err := h.Register("room", bob, bobStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SendTo("room", alice.Id, &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSeqs(t, aliceStream, []uint64{1, 2, 3})
+	waitForSeqs(t, bobStream, []uint64{1, 2})
+
+	// Replays are numbered the same way.
+	if _, err := h.Replay(bobClient, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitForSeqs(t, bobStream, []uint64{1, 2, 1, 2})
+
+	// Someone joining now carries on from the room's messages.
+	carol := rooms.User{Id: uuid.New(), Name: "carol"}
+	if got := h.Seq("room", carol.Id); got != 2 {
+		t.Fatalf("expected carol to start after 2, got %d", got)
+	}
+}
+
+// brokenStream fails every write once released, like a stream whose
+// connection dropped.
+type brokenStream struct {
+	release chan struct{}
+}
+
+func (s *brokenStream) Send(msg *proto.RoomMethod) error {
+	return s.SendSequenced(hub.Message{Method: msg})
+}
+
+func (s *brokenStream) SendSequenced(hub.Message) error {
+	<-s.release
+	return errors.New("connection reset")
+}
+
+func TestHubReattachKeepsMessagesInFlight(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 1})
+	alice := rooms.User{Id: uuid.New(), Name: "alice"}
+
+	broken := &brokenStream{release: make(chan struct{})}
+	client, err := h.Register("room", alice, broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first message is being written when the stream drops, the
+	// others are still queued.
+	for i := 0; i < 4; i++ {
+		if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(broken.release)
+
+	select {
+	case <-client.Broken():
+	case <-time.After(time.Second):
+		t.Fatal("client did not notice its stream broke")
+	}
+
+	select {
+	case <-client.Done():
+		t.Fatalf("expected a broken client to stay open, got %v", client.Err())
+	default:
+	}
+
+	// Messages sent before the user comes back are kept as well.
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Pause()
+	resumed := &sequencedStream{}
+	if _, err := h.Reattach(client, resumed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSeqs(t, resumed, []uint64{1, 2, 3, 4, 5, 6})
+}
+
+func TestHubReplayLargerThanOutbox(t *testing.T) {
+	h := hub.New(hub.Options{Shards: 1, QueueDepth: 4, Policy: hub.PolicyDisconnect, ReplayBuffer: 100})
+	alice := rooms.User{Id: uuid.New(), Name: "alice"}
+
+	for i := 0; i < 100; i++ {
+		if err := h.Broadcast("room", &proto.RoomMethod{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := &sequencedStream{}
+	client, err := h.Register("room", alice, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := h.Replay(client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 100 {
+		t.Fatalf("expected 100 replayed messages, got %d", n)
+	}
+
+	want := make([]uint64, 100)
+	for i := range want {
+		want[i] = uint64(i + 1)
+	}
+	waitForSeqs(t, stream, want)
+
+	select {
+	case <-client.Done():
+		t.Fatalf("expected the client to stay connected, got %v", client.Err())
+	default:
+	}
+}
+
+// writingStream blocks in Send until released, reporting when a write
+// started.
+type writingStream struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *writingStream) Send(*proto.RoomMethod) error {
+	s.writing <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestHubUnregisterWaitsForWriter(t *testing.T) {
+	h := hub.New(hub.Options{})
+
+	stream := &writingStream{writing: make(chan struct{}, 1), release: make(chan struct{})}
+	client, err := h.Register("room", rooms.User{Id: uuid.New(), Name: "alice"}, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Send(&proto.RoomMethod{}); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.writing
+
+	unregistered := make(chan struct{})
+	go func() {
+		h.Unregister(client)
+		close(unregistered)
+	}()
+
+	select {
+	case <-unregistered:
+		t.Fatal("Unregister returned while the writer was still sending")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(stream.release)
+
+	select {
+	case <-unregistered:
+	case <-time.After(time.Second):
+		t.Fatal("Unregister did not return once the write finished")
+	}
+}
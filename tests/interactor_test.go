@@ -0,0 +1,544 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func newTestInteractor() (rooms.Interactor, *pubsub.Broker[rooms.Event]) {
+	events := pubsub.New[rooms.Event](pubsub.DefaultBufferSize)
+	return rooms.NewInteractor(logger.New(zap.DebugLevel, "test"), memory.NewRepository(), events, invites.NewHMAC([]byte("test")), rooms.Config{DefaultInviteTTL: time.Hour}), events
+}
+
+func TestInteractorCancelledCreate(t *testing.T) {
+	interactor, _ := newTestInteractor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestInteractorCancelledJoin(t *testing.T) {
+	interactor, _ := newTestInteractor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := interactor.JoinRoom(ctx, "room", rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestInteractorCreateRoomWithoutListeners(t *testing.T) {
+	interactor, _ := newTestInteractor()
+
+	start := time.Now()
+	if _, err := interactor.CreateRoom(context.Background(), rooms.CreateRoomParams{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("CreateRoom blocked without listeners")
+	}
+}
+
+func TestInteractorCreateRoomReachesEveryListener(t *testing.T) {
+	interactor, events := newTestInteractor()
+
+	first := events.Subscribe()
+	defer first.Close()
+	second := events.Subscribe()
+	defer second.Close()
+
+	if _, err := interactor.CreateRoom(context.Background(), rooms.CreateRoomParams{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []*pubsub.Subscription[rooms.Event]{first, second} {
+		select {
+		case event := <-sub.C():
+			if event.Type != rooms.EventRoomCreated || event.Room != "room" {
+				t.Fatalf("unexpected event %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("listener did not receive the room")
+		}
+	}
+}
+
+func TestInteractorCloseEmptyRooms(t *testing.T) {
+	ctx := context.Background()
+	interactor, events := newTestInteractor()
+
+	empty, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "empty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	occupied, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "occupied"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := rooms.User{Id: uuid.New(), Name: "user"}
+	if _, err := interactor.JoinRoom(ctx, occupied.Id, user, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := events.Subscribe()
+	defer subscription.Close()
+
+	closed, err := interactor.CloseEmptyRooms(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(closed) != 1 || closed[0] != empty.Id {
+		t.Fatalf("expected only the empty room to be closed, got %v", closed)
+	}
+
+	if _, err := interactor.GetRoom(ctx, empty.Id); !errors.Is(err, rooms.ErrRoomNotFound) {
+		t.Fatalf("expected closed room to be gone, got %v", err)
+	}
+
+	event := <-subscription.C()
+	if event.Type != rooms.EventRoomClosed || event.Room != "empty" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	closed, err = interactor.CloseEmptyRooms(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 0 {
+		t.Fatalf("rooms within the ttl must stay open, got %v", closed)
+	}
+}
+
+func TestInteractorRoomCapacity(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Settings: rooms.Settings{MaxParticipants: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "first"}, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "second"}, rooms.Credentials{})
+	if !errors.Is(err, rooms.ErrRoomFull) {
+		t.Fatalf("expected ErrRoomFull, got %v", err)
+	}
+}
+
+func TestInteractorUnlimitedRoom(t *testing.T) {
+	ctx := context.Background()
+	events := pubsub.New[rooms.Event](pubsub.DefaultBufferSize)
+	interactor := rooms.NewInteractor(logger.New(zap.DebugLevel, "test"), memory.NewRepository(), events, invites.NewHMAC([]byte("test")), rooms.Config{DefaultMaxParticipants: 1})
+
+	unlimited := 0
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", MaxParticipants: &unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Settings.MaxParticipants != 0 {
+		t.Fatalf("expected an unlimited room, got a limit of %d", room.Settings.MaxParticipants)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if _, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: name}, rooms.Credentials{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limited, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "limited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limited.Settings.MaxParticipants != 1 {
+		t.Fatalf("expected the default limit, got %d", limited.Settings.MaxParticipants)
+	}
+}
+
+func TestInteractorOverflowAdmitsViewers(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{
+		Name:     "room",
+		Settings: rooms.Settings{MaxParticipants: 1, Overflow: rooms.OverflowViewers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "first"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Role != rooms.RoleParticipant {
+		t.Fatalf("expected first user to participate, got %s", first.Role)
+	}
+
+	second, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "second"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Role != rooms.RoleViewer {
+		t.Fatalf("expected overflowing user to be a viewer, got %s", second.Role)
+	}
+}
+
+func TestInteractorPasswordProtectedRoom(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(room.PasswordHash) == "secret" {
+		t.Fatal("room password stored in plain text")
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{Password: "wrong"})
+	if !errors.Is(err, rooms.ErrWrongPassword) {
+		t.Fatalf("expected ErrWrongPassword, got %v", err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInteractorPrivateRoomIsHidden(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "secret-room", Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := interactor.GetPublicRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(public) != 0 {
+		t.Fatalf("private room listed: %+v", public)
+	}
+
+	if _, err := interactor.ResolveRoom(ctx, "secret-room"); !errors.Is(err, rooms.ErrRoomNotFound) {
+		t.Fatalf("expected private room to be hidden by name, got %v", err)
+	}
+
+	if _, err := interactor.ResolveRoom(ctx, room.Id); err != nil {
+		t.Fatalf("expected private room to be found by id, got %v", err)
+	}
+}
+
+func TestInteractorRoles(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{
+		Name:     "room",
+		Owner:    "owner",
+		Settings: rooms.Settings{MaxParticipants: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner is not limited by the room capacity.
+	owner, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "owner"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.Role != rooms.RoleOwner {
+		t.Fatalf("expected creator to be the owner, got %s", owner.Role)
+	}
+
+	if _, err := interactor.SetRole(ctx, room.Id, user.Id, owner.Id, rooms.RoleViewer); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	if _, err := interactor.SetRole(ctx, room.Id, owner.Id, user.Id, rooms.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moderator grants survive rejoining.
+	if err := interactor.LeaveRoom(ctx, room.Id, user); err != nil {
+		t.Fatal(err)
+	}
+	user, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != rooms.RoleModerator {
+		t.Fatalf("expected moderator after rejoin, got %s", user.Role)
+	}
+}
+
+func TestInteractorKickAndBan(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Owner: "owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(name string) rooms.User {
+		user, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: name}, rooms.Credentials{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	owner := join("owner")
+	first := join("first")
+	second := join("second")
+
+	if _, err := interactor.KickUser(ctx, room.Id, first.Id, second.Id, false); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected participants to be unable to kick, got %v", err)
+	}
+
+	if _, err := interactor.KickUser(ctx, room.Id, owner.Id, first.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	join("first")
+
+	if _, err := interactor.KickUser(ctx, room.Id, owner.Id, second.Id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "second"}, rooms.Credentials{})
+	if !errors.Is(err, rooms.ErrBanned) {
+		t.Fatalf("expected ErrBanned on rejoin, got %v", err)
+	}
+}
+
+func TestInteractorLobby(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Owner: "owner", Lobby: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := rooms.User{Id: uuid.New(), Name: "owner"}
+	if room.NeedsAdmission(owner) {
+		t.Fatal("expected the owner to skip the lobby")
+	}
+	owner, err = interactor.JoinRoom(ctx, room.Id, owner, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest := rooms.User{Id: uuid.New(), Name: "guest"}
+	if _, err := interactor.JoinRoom(ctx, room.Id, guest, rooms.Credentials{}); !errors.Is(err, rooms.ErrAdmissionRequired) {
+		t.Fatalf("expected ErrAdmissionRequired, got %v", err)
+	}
+
+	if err := interactor.EnterLobby(ctx, room.Id, guest, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.AdmitUser(ctx, room.Id, owner.Id, guest.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.JoinRoom(ctx, room.Id, guest, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	denied := rooms.User{Id: uuid.New(), Name: "denied"}
+	if err := interactor.EnterLobby(ctx, room.Id, denied, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.DenyUser(ctx, room.Id, owner.Id, denied.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.JoinRoom(ctx, room.Id, denied, rooms.Credentials{}); !errors.Is(err, rooms.ErrAdmissionRequired) {
+		t.Fatalf("expected denied user to still need admission, got %v", err)
+	}
+}
+
+func TestInteractorUnusedAdmissionIsDropped(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Owner: "owner", Lobby: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "owner"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest := rooms.User{Id: uuid.New(), Name: "guest"}
+	if err := interactor.EnterLobby(ctx, room.Id, guest, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interactor.AdmitUser(ctx, room.Id, owner.Id, guest.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest disconnected before joining.
+	if err := interactor.LeaveLobby(ctx, room.Id, guest.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	room, err = interactor.GetRoom(ctx, room.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(room.Admitted) != 0 {
+		t.Fatalf("expected the unused admission to be dropped, got %v", room.Admitted)
+	}
+
+	if _, err := interactor.JoinRoom(ctx, room.Id, guest, rooms.Credentials{}); !errors.Is(err, rooms.ErrAdmissionRequired) {
+		t.Fatalf("expected the guest to need admission again, got %v", err)
+	}
+}
+
+func TestInteractorLockedRoom(t *testing.T) {
+	ctx := context.Background()
+	interactor, events := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Owner: "owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "owner"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "user"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := interactor.SetRoomLocked(ctx, room.Id, user.Id, true); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	subscription := events.Subscribe()
+	defer subscription.Close()
+
+	if err := interactor.SetRoomLocked(ctx, room.Id, owner.Id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-subscription.C():
+		if event.Type != rooms.EventRoomLocked || !event.Locked {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a locked event")
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "late"}, rooms.Credentials{})
+	if !errors.Is(err, rooms.ErrRoomLocked) {
+		t.Fatalf("expected ErrRoomLocked, got %v", err)
+	}
+
+	if err := interactor.SetRoomLocked(ctx, room.Id, owner.Id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "late"}, rooms.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInteractorInvites(t *testing.T) {
+	ctx := context.Background()
+	interactor, _ := newTestInteractor()
+
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "room", Owner: "owner", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "owner"}, rooms.Credentials{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, token, err := interactor.CreateInvite(ctx, room.Id, owner.Id, rooms.InviteParams{Role: rooms.RoleViewer, MaxUses: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "guest"}, rooms.Credentials{Invite: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Role != rooms.RoleViewer {
+		t.Fatalf("expected the invite role, got %s", guest.Role)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "other"}, rooms.Credentials{Invite: token})
+	if !errors.Is(err, rooms.ErrInviteExhausted) {
+		t.Fatalf("expected ErrInviteExhausted, got %v", err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "other"}, rooms.Credentials{Invite: token + "x"})
+	if !errors.Is(err, rooms.ErrInviteInvalid) {
+		t.Fatalf("expected a tampered invite to be rejected, got %v", err)
+	}
+
+	invite, token, err = interactor.CreateInvite(ctx, room.Id, owner.Id, rooms.InviteParams{Username: "bound"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "other"}, rooms.Credentials{Invite: token})
+	if !errors.Is(err, rooms.ErrInviteInvalid) {
+		t.Fatalf("expected an invite bound to another username to be rejected, got %v", err)
+	}
+
+	if err := interactor.RevokeInvite(ctx, room.Id, owner.Id, invite.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "bound"}, rooms.Credentials{Invite: token})
+	if !errors.Is(err, rooms.ErrInviteRevoked) {
+		t.Fatalf("expected ErrInviteRevoked, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLobbyStream(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Options{HMACSecret: testJWTSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, _, client := newSessionTestServer(t, grpc.ChainStreamInterceptor(transport.AuthStreamInterceptor(verifier)))
+
+	bearer := func(sub, name string) string {
+		return "Bearer " + signHS256(t, jwt.MapClaims{"sub": sub, "preferred_username": name, "exp": time.Now().Add(time.Minute).Unix()})
+	}
+	aliceID, bobID := uuid.New(), uuid.New()
+
+	ownerCtx := metadata.NewIncomingContext(
+		auth.WithIdentity(context.Background(), auth.Identity{Subject: aliceID.String(), Username: "alice"}),
+		metadata.Pairs("room-lobby", "true"),
+	)
+	for _, name := range []string{"first", "second"} {
+		if _, err := service.CreateRoom(ownerCtx, &proto.CreateRoomRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliceFirst, _ := joinRoom(t, context.Background(), client, "authorization", bearer(aliceID.String(), "alice"), "room_name", "first")
+	aliceSecond, _ := joinRoom(t, context.Background(), client, "authorization", bearer(aliceID.String(), "alice"), "room_name", "second")
+
+	// Bob waits in both lobbies at once with the same user id.
+	waitInLobby := func(room string, owner proto.RoomsService_JoinRoomClient) (proto.RoomsService_JoinRoomClient, <-chan metadata.MD) {
+		t.Helper()
+
+		stream, err := client.JoinRoom(metadata.AppendToOutgoingContext(context.Background(), "authorization", bearer(bobID.String(), "bob"), "room_name", room))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recvUntil(t, owner, func(msg *proto.RoomMethod) bool {
+			event, ok := dispatched(msg)
+			return ok && event.Type == "join_request"
+		})
+
+		header := make(chan metadata.MD, 1)
+		go func() {
+			md, _ := stream.Header()
+			header <- md
+		}()
+
+		return stream, header
+	}
+	bobFirst, firstHeader := waitInLobby("first", aliceFirst)
+	bobSecond, secondHeader := waitInLobby("second", aliceSecond)
+
+	sendText(t, aliceFirst, "before you came in")
+	recvUntil(t, aliceFirst, func(msg *proto.RoomMethod) bool { return msg.GetMessageReceived().GetUsername() == "alice" })
+
+	select {
+	case <-firstHeader:
+		t.Fatal("expected no response headers while waiting in the lobby")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendText(t, aliceFirst, "/admit "+bobID.String())
+
+	select {
+	case header := <-firstHeader:
+		if len(header.Get("user-id")) == 0 || header.Get("user-id")[0] != bobID.String() {
+			t.Fatalf("expected response headers once admitted, got %v", header)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("admitted user got no response headers")
+	}
+
+	recvUntil(t, bobFirst, func(msg *proto.RoomMethod) bool {
+		if received := msg.GetMessageReceived(); received != nil && received.Username == "alice" {
+			t.Fatalf("expected no room traffic from before the admission, got %q", received.Text)
+		}
+		return msg.GetRoomUsers_() != nil
+	})
+
+	sendText(t, aliceFirst, "welcome")
+	msg := recvUntil(t, bobFirst, func(msg *proto.RoomMethod) bool { return msg.GetMessageReceived().GetUsername() == "alice" })
+	if msg.GetMessageReceived().Text != "welcome" {
+		t.Fatalf("unexpected message %q", msg.GetMessageReceived().Text)
+	}
+
+	// Admission to the first room left Bob waiting in the second one.
+	select {
+	case <-secondHeader:
+		t.Fatal("expected bob to still wait in the second lobby")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendText(t, aliceSecond, "/deny "+bobID.String())
+	_, err = bobSecond.Recv()
+	if code, reason := errorReason(t, err); code != codes.PermissionDenied || reason != "JOIN_DENIED" {
+		t.Fatalf("expected a denied join, got %s %s", code, reason)
+	}
+}
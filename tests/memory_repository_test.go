@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	"github.com/google/uuid"
+)
+
+// The tests below are meant to be run with -race.
+
+func newRoom(name string) rooms.Room {
+	return rooms.Room{Id: name, Name: name}
+}
+
+func TestMemoryRepositoryConcurrentMembership(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewShardedRepository(4)
+
+	const (
+		roomsCount     = 8
+		usersPerRoom   = 64
+		readersPerRoom = 4
+	)
+
+	for i := 0; i < roomsCount; i++ {
+		if _, err := repository.CreateRoom(ctx, newRoom(fmt.Sprintf("room-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < roomsCount; i++ {
+		name := fmt.Sprintf("room-%d", i)
+
+		for j := 0; j < usersPerRoom; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				user := rooms.User{Id: uuid.New(), Name: fmt.Sprintf("user-%d", j)}
+				if _, err := repository.JoinRoom(ctx, name, user); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if j%2 == 0 {
+					if _, err := repository.LeaveRoom(ctx, name, user); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+
+		for j := 0; j < readersPerRoom; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for k := 0; k < usersPerRoom; k++ {
+					users, err := repository.GetRoomUsers(ctx, name)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					// Results are copies and must be safe to mutate.
+					for idx := range users {
+						users[idx].Name = ""
+					}
+
+					if _, err := repository.GetRooms(ctx); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < roomsCount; i++ {
+		users, err := repository.GetRoomUsers(ctx, fmt.Sprintf("room-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(users) != usersPerRoom/2 {
+			t.Fatalf("expected %d users, got %d", usersPerRoom/2, len(users))
+		}
+
+		for _, u := range users {
+			if u.Name == "" {
+				t.Fatal("mutating a returned slice leaked into the repository")
+			}
+		}
+	}
+}
+
+func TestMemoryRepositoryConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewRepository()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := repository.CreateRoom(ctx, newRoom(fmt.Sprintf("room-%d", i))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	allRooms, err := repository.GetRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(allRooms) != 100 {
+		t.Fatalf("expected 100 rooms, got %d", len(allRooms))
+	}
+}
+
+func TestMemoryRepositoryLeaveDoesNotAliasReturnedUsers(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewRepository()
+	if _, err := repository.CreateRoom(ctx, newRoom("room")); err != nil {
+		t.Fatal(err)
+	}
+
+	first := rooms.User{Id: uuid.New(), Name: "first"}
+	second := rooms.User{Id: uuid.New(), Name: "second"}
+	for _, u := range []rooms.User{first, second} {
+		if _, err := repository.JoinRoom(ctx, "room", u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := repository.GetRoomUsers(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.LeaveRoom(ctx, "room", first); err != nil {
+		t.Fatal(err)
+	}
+
+	if before[0].Id != first.Id || before[1].Id != second.Id {
+		t.Fatal("LeaveRoom modified a previously returned slice")
+	}
+}
+
+func TestMemoryRepositoryCancelledContext(t *testing.T) {
+	repository := memory.NewRepository()
+	if _, err := repository.CreateRoom(context.Background(), newRoom("room")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	user := rooms.User{Id: uuid.New(), Name: "user"}
+
+	if _, err := repository.CreateRoom(ctx, newRoom("other")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CreateRoom: expected context.Canceled, got %v", err)
+	}
+	if _, err := repository.JoinRoom(ctx, "room", user); !errors.Is(err, context.Canceled) {
+		t.Fatalf("JoinRoom: expected context.Canceled, got %v", err)
+	}
+	if _, err := repository.LeaveRoom(ctx, "room", user); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LeaveRoom: expected context.Canceled, got %v", err)
+	}
+	if _, err := repository.GetRoomUsers(ctx, "room"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetRoomUsers: expected context.Canceled, got %v", err)
+	}
+	if _, err := repository.GetRooms(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetRooms: expected context.Canceled, got %v", err)
+	}
+
+	allRooms, err := repository.GetRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allRooms) != 1 {
+		t.Fatalf("cancelled CreateRoom must not store a room, got %d rooms", len(allRooms))
+	}
+}
+
+func TestMemoryRepositoryCreateRoomConflict(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewRepository()
+
+	if _, err := repository.CreateRoom(ctx, rooms.Room{Id: "first", Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.JoinRoom(ctx, "first", rooms.User{Id: uuid.New(), Name: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.CreateRoom(ctx, rooms.Room{Id: "second", Name: "room"}); !errors.Is(err, rooms.ErrRoomExists) {
+		t.Fatalf("expected ErrRoomExists, got %v", err)
+	}
+
+	users, err := repository.GetRoomUsers(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatal("a conflicting CreateRoom wiped the existing room")
+	}
+}
+
+func TestMemoryRepositoryIdempotentCreate(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewRepository()
+
+	first, err := repository.CreateRoom(ctx, rooms.Room{Id: "first", Name: "room", IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := repository.CreateRoom(ctx, rooms.Room{Id: "second", Name: "room", IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.Id != first.Id {
+		t.Fatalf("expected the replayed create to return room %s, got %s", first.Id, replayed.Id)
+	}
+}
+
+func TestMemoryRepositoryRenameKeepsId(t *testing.T) {
+	ctx := context.Background()
+	repository := memory.NewRepository()
+
+	for _, room := range []rooms.Room{{Id: "a", Name: "alpha"}, {Id: "b", Name: "beta"}} {
+		if _, err := repository.CreateRoom(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repository.RenameRoom(ctx, "a", "beta"); !errors.Is(err, rooms.ErrRoomExists) {
+		t.Fatalf("expected ErrRoomExists, got %v", err)
+	}
+
+	if _, err := repository.RenameRoom(ctx, "a", "gamma"); err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := repository.GetRoomByName(ctx, "gamma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Id != "a" {
+		t.Fatalf("expected renamed room to keep id a, got %s", room.Id)
+	}
+
+	if _, err := repository.GetRoomByName(ctx, "alpha"); !errors.Is(err, rooms.ErrRoomNotFound) {
+		t.Fatalf("expected old name to be released, got %v", err)
+	}
+}
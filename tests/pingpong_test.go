@@ -3,9 +3,14 @@ package tests
 import (
 	"context"
 	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
 	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
 	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
 	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/test/bufconn"
@@ -27,7 +32,7 @@ func init() {
 	repository := memory.NewRepository()
 
 	grpcServer := grpc.NewServer(opts...)
-	proto.RegisterRoomsServiceServer(grpcServer, transport.NewRoomsService(mainLogger, repository))
+	proto.RegisterRoomsServiceServer(grpcServer, transport.NewRoomsService(mainLogger, repository, pubsub.New[rooms.Event](pubsub.DefaultBufferSize), invites.NewHMAC([]byte("test")), auth.AllowAll{}, hub.New(hub.Options{}), transport.SessionOptions{}, transport.SignalingOptions{}, rooms.Config{}))
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Fatalf("Server exited with error: %v", err)
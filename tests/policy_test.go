@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - effect: deny
+    users: [mallory]
+  - effect: allow
+    actions: [create_room]
+    groups: [hosts]
+  - effect: allow
+    actions: [join_room, chat, publish]
+    rooms: ["support-*"]
+`
+
+func TestPolicyAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := auth.NewPolicyAuthorizer(path, logger.New(zap.DebugLevel, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := rooms.Principal{Identity: "alice", Groups: []string{"hosts"}}
+	guest := rooms.Principal{Identity: "bob"}
+	banned := rooms.Principal{Identity: "mallory", Groups: []string{"hosts"}}
+
+	cases := []struct {
+		request rooms.AccessRequest
+		allowed bool
+	}{
+		{rooms.AccessRequest{Principal: host, Action: rooms.ActionCreateRoom, RoomName: "anything"}, true},
+		{rooms.AccessRequest{Principal: guest, Action: rooms.ActionCreateRoom, RoomName: "anything"}, false},
+		{rooms.AccessRequest{Principal: banned, Action: rooms.ActionCreateRoom, RoomName: "anything"}, false},
+		{rooms.AccessRequest{Principal: guest, Action: rooms.ActionJoinRoom, RoomID: "id", RoomName: "support-1"}, true},
+		{rooms.AccessRequest{Principal: guest, Action: rooms.ActionJoinRoom, RoomID: "id", RoomName: "sales"}, false},
+		{rooms.AccessRequest{Principal: guest, Action: rooms.ActionModerate, RoomID: "id", RoomName: "support-1"}, false},
+	}
+
+	for _, c := range cases {
+		err := authorizer.Authorize(ctx, c.request)
+		if c.allowed && err != nil {
+			t.Errorf("expected %+v to be allowed, got %v", c.request, err)
+		}
+		if !c.allowed && !errors.Is(err, rooms.ErrForbidden) {
+			t.Errorf("expected %+v to be forbidden, got %v", c.request, err)
+		}
+	}
+}
+
+func TestPolicyAuthorizerDefaultsToDeny(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := "rules:\n  - effect: allow\n    actions: [create_room]\n"
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := auth.NewPolicyAuthorizer(path, logger.New(zap.DebugLevel, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal := rooms.Principal{Identity: "alice"}
+	if err := authorizer.Authorize(ctx, rooms.AccessRequest{Principal: principal, Action: rooms.ActionCreateRoom}); err != nil {
+		t.Fatalf("expected the rule to allow creating rooms, got %v", err)
+	}
+
+	request := rooms.AccessRequest{Principal: principal, Action: rooms.ActionJoinRoom, RoomID: "id", RoomName: "room"}
+	if err := authorizer.Authorize(ctx, request); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected a policy without a default to deny, got %v", err)
+	}
+}
+
+func TestPolicyAuthorizerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("default: deny\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := auth.NewPolicyAuthorizer(path, logger.New(zap.DebugLevel, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go authorizer.Watch(ctx, 10*time.Millisecond)
+
+	request := rooms.AccessRequest{Principal: rooms.Principal{Identity: "alice"}, Action: rooms.ActionCreateRoom}
+	if err := authorizer.Authorize(ctx, request); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	// An invalid policy keeps the previous one.
+	if err := os.WriteFile(path, []byte("default: maybe\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := authorizer.Authorize(ctx, request); !errors.Is(err, rooms.ErrForbidden) {
+		t.Fatalf("expected the previous policy to stay, got %v", err)
+	}
+
+	modTime := time.Now().Add(time.Second)
+	if err := os.WriteFile(path, []byte("default: allow\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for authorizer.Authorize(ctx, request) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("policy was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPolicyCoversEveryRoomMethod(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := "default: allow\nrules:\n  - effect: deny\n    actions: [signal, replay, ice_servers]\n"
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := auth.NewPolicyAuthorizer(path, logger.New(zap.DebugLevel, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, _, client := newAuthorizedTestServer(t, authorizer)
+
+	room, err := service.CreateRoom(context.Background(), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, context.Background(), client, "username", "alice", "room_name", room.Name)
+	_, bobHeader := joinRoom(t, context.Background(), client, "username", "bob", "room_name", room.Name)
+	bobID := bobHeader.Get("user-id")[0]
+
+	candidate := &proto.RoomMethod{Method: &proto.RoomMethod_SendIceCandidate{SendIceCandidate: &proto.SendIceCandidate{
+		Candidate: `{"to": "` + bobID + `", "candidate": "candidate:1 1 udp 2122260223 10.0.0.2 54321 typ host", "sdpMid": "0"}`,
+	}}}
+	answer := &proto.RoomMethod{Method: &proto.RoomMethod_SendSdp{SendSdp: &proto.SendSDP{
+		Sdp: []*proto.SDP{{Type: "answer", Username: bobID, Sdp: "v=0"}},
+	}}}
+
+	send := []func(){
+		func() { sendMethod(t, alice, candidate) },
+		func() { sendMethod(t, alice, answer) },
+		func() { sendText(t, alice, "/replay 0") },
+		func() { sendText(t, alice, "/ice-servers") },
+	}
+
+	for i, send := range send {
+		send()
+
+		msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+			event, ok := dispatched(msg)
+			return ok && event.Type == "error"
+		})
+		if event, _ := dispatched(msg); event.Code != codes.PermissionDenied.String() {
+			t.Fatalf("method %d: expected the policy to deny it, got %+v", i, event)
+		}
+	}
+}
+
+func sendMethod(t *testing.T, stream proto.RoomsService_JoinRoomClient, method *proto.RoomMethod) {
+	t.Helper()
+
+	if err := stream.Send(method); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,35 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
+)
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := pubsub.New[int](2)
+
+	slow := broker.Subscribe()
+	fast := broker.Subscribe()
+	defer fast.Close()
+
+	for i := 0; i < 3; i++ {
+		broker.Publish(i)
+		<-fast.C()
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow subscriber was not dropped")
+	}
+
+	if !errors.Is(slow.Err(), pubsub.ErrSlowSubscriber) {
+		t.Fatalf("expected ErrSlowSubscriber, got %v", slow.Err())
+	}
+
+	if broker.Subscribers() != 1 {
+		t.Fatalf("expected one subscriber left, got %d", broker.Subscribers())
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+type roomEventLine struct {
+	Result struct {
+		Type string `json:"type"`
+		Room struct {
+			Name         string `json:"name"`
+			Participants int    `json:"participants"`
+		} `json:"room"`
+		Rooms []struct {
+			Name string `json:"name"`
+		} `json:"rooms"`
+	} `json:"result"`
+}
+
+func newGatewayTestServer(t *testing.T) (*transport.RoomsService, *httptest.Server) {
+	t.Helper()
+
+	service := transport.NewRoomsService(
+		logger.New(zap.DebugLevel, "test"),
+		memory.NewRepository(),
+		pubsub.New[rooms.Event](pubsub.DefaultBufferSize),
+		invites.NewHMAC([]byte("test")),
+		auth.AllowAll{},
+		hub.New(hub.Options{}),
+		transport.SessionOptions{},
+		transport.SignalingOptions{},
+		rooms.Config{},
+	)
+
+	mux := runtime.NewServeMux()
+	if err := transport.RegisterGatewayRoutes(mux, service, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return service, server
+}
+
+func TestRoomEventsSnapshotThenDeltas(t *testing.T) {
+	ctx := context.Background()
+	service, server := newGatewayTestServer(t)
+
+	if _, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "existing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/rooms/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() roomEventLine {
+		if !lines.Scan() {
+			t.Fatalf("event stream ended: %v", lines.Err())
+		}
+
+		var line roomEventLine
+		if err := json.Unmarshal(lines.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+
+		return line
+	}
+
+	snapshot := next()
+	if snapshot.Result.Type != "snapshot" || len(snapshot.Result.Rooms) != 1 || snapshot.Result.Rooms[0].Name != "existing" {
+		t.Fatalf("unexpected snapshot %+v", snapshot.Result)
+	}
+
+	if _, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	created := next()
+	if created.Result.Type != string(rooms.EventRoomCreated) || created.Result.Room.Name != "new" {
+		t.Fatalf("unexpected event %+v", created.Result)
+	}
+}
+
+func gatewayRequest(t *testing.T, method, url, username string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		req.Header.Set("Username", username)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestCloseRoomRequiresModerator(t *testing.T) {
+	service, server := newGatewayTestServer(t)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "owner"))
+	if _, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "owned"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := gatewayRequest(t, http.MethodDelete, server.URL+"/rooms/owned", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected anonymous callers to be refused, got %s", resp.Status)
+	}
+	if resp := gatewayRequest(t, http.MethodDelete, server.URL+"/rooms/owned", "mallory", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected non members to get permission denied, got %s", resp.Status)
+	}
+
+	if resp := gatewayRequest(t, http.MethodDelete, server.URL+"/rooms/owned", "owner", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the owner to close the room, got %s", resp.Status)
+	}
+}
+
+func TestUpdateRoomMetadataRequiresModerator(t *testing.T) {
+	service, server := newGatewayTestServer(t)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "owner"))
+	if _, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "owned"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := gatewayRequest(t, http.MethodPut, server.URL+"/rooms/owned/metadata", "mallory", strings.NewReader(`{"topic":"spam"}`)); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected non members to get permission denied, got %s", resp.Status)
+	}
+	if resp := gatewayRequest(t, http.MethodPut, server.URL+"/rooms/owned/metadata", "owner", strings.NewReader(`{"topic":"go"}`)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the owner to update the metadata, got %s", resp.Status)
+	}
+}
+
+func TestRenameRoomRequiresModerator(t *testing.T) {
+	service, server := newGatewayTestServer(t)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "owner"))
+	if _, err := service.CreateRoom(ctx, &proto.CreateRoomRequest{Name: "owned"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := gatewayRequest(t, http.MethodPut, server.URL+"/rooms/owned/name", "mallory", strings.NewReader(`{"name":"taken"}`)); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected non members to get permission denied, got %s", resp.Status)
+	}
+	if resp := gatewayRequest(t, http.MethodPut, server.URL+"/rooms/owned/name", "owner", strings.NewReader(`{"name":"renamed"}`)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the owner to rename the room, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type dispatcherJSON struct {
+	Type      string          `json:"type"`
+	Seq       uint64          `json:"seq"`
+	RequestID string          `json:"request_id"`
+	Code      string          `json:"code"`
+	Reason    string          `json:"reason"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func dispatched(msg *proto.RoomMethod) (dispatcherJSON, bool) {
+	received := msg.GetMessageReceived()
+	if received == nil || received.Username != "dispatcher" {
+		return dispatcherJSON{}, false
+	}
+
+	event := dispatcherJSON{}
+	return event, json.Unmarshal([]byte(received.Text), &event) == nil
+}
+
+func sendText(t *testing.T, stream proto.RoomsService_JoinRoomClient, text string) {
+	t.Helper()
+
+	err := stream.Send(&proto.RoomMethod{Method: &proto.RoomMethod_SendMessage{
+		SendMessage: &proto.SendMessageRequest{Text: text},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSequencedRequests(t *testing.T) {
+	service, _, client := newSessionTestServer(t)
+
+	room, err := service.CreateRoom(context.Background(), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, header := joinRoom(t, context.Background(), client, "username", "alice", "room_name", room.Name, "sequenced", "true")
+	if len(header.Get("room-seq")) == 0 {
+		t.Fatal("expected a room-seq header")
+	}
+
+	sendText(t, alice, `/request r1 {"sendMessage": {"text": "hello"}}`)
+
+	var chatSeq uint64
+	acked := false
+	for chatSeq == 0 || !acked {
+		msg, err := alice.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		event, ok := dispatched(msg)
+		if !ok {
+			t.Fatalf("expected only dispatcher messages, got %+v", msg)
+		}
+
+		switch event.Type {
+		case "ack":
+			if event.RequestID != "r1" {
+				t.Fatalf("unexpected ack %+v", event)
+			}
+			acked = true
+
+		case "event":
+			if event.Seq == 0 {
+				t.Fatalf("expected a sequence number, got %+v", event)
+			}
+
+			inner := &proto.RoomMethod{}
+			if err := protojson.Unmarshal(event.Data, inner); err != nil {
+				t.Fatal(err)
+			}
+			if inner.GetMessageReceived().GetText() == "hello" {
+				chatSeq = event.Seq
+			}
+		}
+	}
+
+	sendText(t, alice, "/request r2 /replay "+strconv.FormatUint(chatSeq-1, 10))
+	msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, _ := dispatched(msg)
+		return event.RequestID == "r2"
+	})
+	if event, _ := dispatched(msg); event.Type != "error" {
+		t.Fatalf("expected an invalid request error, got %+v", event)
+	}
+
+	sendText(t, alice, `/request r3 {"sendMessage": {"text": "/replay `+strconv.FormatUint(chatSeq-1, 10)+`"}}`)
+	msg = recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, _ := dispatched(msg)
+		return event.Type == "event" || event.RequestID == "r3"
+	})
+	if event, _ := dispatched(msg); event.Seq != chatSeq {
+		t.Fatalf("expected message %d to be replayed first, got %+v", chatSeq, event)
+	}
+
+	msg = recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, _ := dispatched(msg)
+		return event.RequestID == "r3"
+	})
+	if event, _ := dispatched(msg); event.Type != "ack" {
+		t.Fatalf("expected replay to be acknowledged, got %+v", event)
+	}
+}
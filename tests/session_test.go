@@ -0,0 +1,330 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/tmc/grpc-websocket-proxy/wsproxy"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newSessionTestServer(t *testing.T, opts ...grpc.ServerOption) (*transport.RoomsService, *hub.Hub, proto.RoomsServiceClient) {
+	t.Helper()
+
+	return newAuthorizedTestServer(t, auth.AllowAll{}, opts...)
+}
+
+func newAuthorizedTestServer(t *testing.T, authorizer rooms.Authorizer, opts ...grpc.ServerOption) (*transport.RoomsService, *hub.Hub, proto.RoomsServiceClient) {
+	t.Helper()
+
+	roomsHub := hub.New(hub.Options{})
+	service := transport.NewRoomsService(
+		logger.New(zap.DebugLevel, "test"),
+		memory.NewRepository(),
+		pubsub.New[rooms.Event](pubsub.DefaultBufferSize),
+		invites.NewHMAC([]byte("test")),
+		authorizer,
+		roomsHub,
+		transport.SessionOptions{GracePeriod: time.Minute, ReplayBuffer: 16},
+		transport.SignalingOptions{CandidateBuffer: 16, CandidateTTL: time.Minute},
+		rooms.Config{},
+	)
+
+	listener := bufconn.Listen(bufSize)
+	server := grpc.NewServer(opts...)
+	proto.RegisterRoomsServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return service, roomsHub, proto.NewRoomsServiceClient(conn)
+}
+
+func joinRoom(t *testing.T, ctx context.Context, client proto.RoomsServiceClient, kv ...string) (proto.RoomsService_JoinRoomClient, metadata.MD) {
+	t.Helper()
+
+	stream, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, kv...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Get("user-id")) == 0 {
+		_, err := stream.Recv()
+		t.Fatalf("join failed: %v", err)
+	}
+
+	return stream, header
+}
+
+// recvUntil reads messages until match accepts one.
+func recvUntil(t *testing.T, stream proto.RoomsService_JoinRoomClient, match func(*proto.RoomMethod) bool) *proto.RoomMethod {
+	t.Helper()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	service, roomsHub, client := newSessionTestServer(t)
+
+	room, err := service.CreateRoom(context.Background(), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceCtx, dropAlice := context.WithCancel(context.Background())
+	_, aliceHeader := joinRoom(t, aliceCtx, client, "username", "alice", "room_name", room.Name)
+	token := aliceHeader.Get("session-token")
+	if len(token) == 0 {
+		t.Fatal("expected a session token")
+	}
+	aliceID := uuid.MustParse(aliceHeader.Get("user-id")[0])
+	roomID := aliceHeader.Get("room-id")[0]
+
+	bob, _ := joinRoom(t, context.Background(), client, "username", "bob", "room_name", room.Name)
+
+	attached, ok := roomsHub.Client(roomID, aliceID)
+	if !ok {
+		t.Fatal("alice is not registered")
+	}
+
+	dropAlice()
+
+	select {
+	case <-attached.Done():
+	case <-time.After(time.Second):
+		t.Fatal("alice's session was not detached")
+	}
+
+	err = bob.Send(&proto.RoomMethod{Method: &proto.RoomMethod_SendMessage{
+		SendMessage: &proto.SendMessageRequest{Text: "while you were away"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob gets his own message once it went out to the whole room.
+	recvUntil(t, bob, func(msg *proto.RoomMethod) bool {
+		return msg.GetMessageReceived().GetUsername() == "bob"
+	})
+
+	resumed, header := joinRoom(t, context.Background(), client, "username", "alice", "session-token", token[0])
+	if header.Get("user-id")[0] != aliceID.String() {
+		t.Fatalf("expected to resume as %s, got %v", aliceID, header.Get("user-id"))
+	}
+
+	msg := recvUntil(t, resumed, func(msg *proto.RoomMethod) bool {
+		received := msg.GetMessageReceived()
+		return received != nil && received.Username == "bob"
+	})
+	if msg.GetMessageReceived().Text != "while you were away" {
+		t.Fatalf("unexpected replayed message %+v", msg)
+	}
+
+	users := recvUntil(t, resumed, func(msg *proto.RoomMethod) bool { return msg.GetRoomUsers_() != nil })
+	if len(users.GetRoomUsers_().Users) != 2 {
+		t.Fatalf("expected alice to have stayed in the room, got %+v", users.GetRoomUsers_().Users)
+	}
+}
+
+func TestFreshJoinReplacesDetachedSession(t *testing.T) {
+	ctx := context.Background()
+	_, roomsHub, client := newSessionTestServer(t)
+
+	if _, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice"), &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceCtx, dropAlice := context.WithCancel(ctx)
+	_, header := joinRoom(t, aliceCtx, client, "username", "alice", "room_name", "room")
+	token := header.Get("session-token")[0]
+
+	attached, ok := roomsHub.Client(header.Get("room-id")[0], uuid.MustParse(header.Get("user-id")[0]))
+	if !ok {
+		t.Fatal("alice is not registered")
+	}
+
+	dropAlice()
+
+	select {
+	case <-attached.Done():
+	case <-time.After(time.Second):
+		t.Fatal("alice's session was not detached")
+	}
+
+	// Alice lost her session token, e.g. with the page reloaded.
+	rejoined, _ := joinRoom(t, ctx, client, "username", "alice", "room_name", "room")
+	users := recvUntil(t, rejoined, func(msg *proto.RoomMethod) bool { return msg.GetRoomUsers_() != nil })
+	if len(users.GetRoomUsers_().Users) != 1 {
+		t.Fatalf("expected the detached session to be gone, got %+v", users.GetRoomUsers_().Users)
+	}
+
+	stale, err := client.JoinRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice", "session-token", token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stale.Recv(); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the replaced session token to fail with NOT_FOUND, got %v", err)
+	}
+}
+
+// The gateway half-closes the stream when a websocket is closed, which
+// must not be taken for leaving the room.
+func TestHalfClosedStreamDetachesSession(t *testing.T) {
+	ctx := context.Background()
+	_, roomsHub, client := newSessionTestServer(t)
+
+	if _, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "alice"), &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+	alice, header := joinRoom(t, ctx, client, "username", "alice", "room_name", "room")
+
+	attached, ok := roomsHub.Client(header.Get("room-id")[0], uuid.MustParse(header.Get("user-id")[0]))
+	if !ok {
+		t.Fatal("alice is not registered")
+	}
+
+	if err := alice.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-attached.Done():
+	case <-time.After(time.Second):
+		t.Fatal("alice's stream did not end")
+	}
+	if !errors.Is(attached.Err(), hub.ErrReattached) {
+		t.Fatalf("expected alice's session to be detached, got %v", attached.Err())
+	}
+}
+
+func TestClosedWebsocketDetachesSession(t *testing.T) {
+	ctx := context.Background()
+	_, roomsHub, client := newSessionTestServer(t)
+
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(transport.RoomsHeaderMatcher))
+	if err := proto.RegisterRoomsServiceHandlerClient(ctx, mux, client); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(wsproxy.WebsocketProxy(mux,
+		wsproxy.WithRequestMutator(transport.WebsocketParamMutator),
+		wsproxy.WithForwardedHeaders(func(header string) bool {
+			_, ok := transport.RoomsHeaderMatcher(header)
+			return ok
+		}),
+	))
+	t.Cleanup(server.Close)
+
+	if _, err := client.CreateRoom(metadata.AppendToOutgoingContext(ctx, "username", "bob"), &proto.CreateRoomRequest{Name: "room"}); err != nil {
+		t.Fatal(err)
+	}
+	bob, header := joinRoom(t, ctx, client, "username", "bob", "room_name", "room")
+	roomID := header.Get("room-id")[0]
+
+	// dial joins over a websocket, returning the hub client of the user
+	// once Bob sees them in the room.
+	dial := func(username string) (*websocket.Conn, *hub.Client) {
+		t.Helper()
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/join-room?username=" + username + "&room-name=room"
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		var id uuid.UUID
+		recvUntil(t, bob, func(msg *proto.RoomMethod) bool {
+			for _, u := range msg.GetRoomUsers_().GetUsers() {
+				if u.Username == username {
+					id = uuid.MustParse(u.Id)
+					return true
+				}
+			}
+			return false
+		})
+
+		attached, ok := roomsHub.Client(roomID, id)
+		if !ok {
+			t.Fatalf("%s is not registered", username)
+		}
+
+		return conn, attached
+	}
+
+	waitClosed := func(client *hub.Client) error {
+		t.Helper()
+
+		select {
+		case <-client.Done():
+			return client.Err()
+		case <-time.After(time.Second):
+			t.Fatal("the stream did not end")
+			return nil
+		}
+	}
+
+	// Closing the tab only closes the websocket, the user may still come
+	// back.
+	alice, attached := dial("alice")
+	closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	if err := alice.WriteMessage(websocket.CloseMessage, closing); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitClosed(attached); !errors.Is(err, hub.ErrReattached) {
+		t.Fatalf("expected alice's session to be detached, got %v", err)
+	}
+
+	carol, attached := dial("carol")
+	if err := carol.WriteMessage(websocket.TextMessage, []byte(`{"sendMessage": {"text": "/leave"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitClosed(attached); err != nil {
+		t.Fatalf("expected carol to leave the room, got %v", err)
+	}
+	if _, ok := roomsHub.Client(roomID, attached.User.Id); ok {
+		t.Fatal("carol is still registered after leaving")
+	}
+}
@@ -0,0 +1,434 @@
+package tests
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitgernit/videochat-contracts/proto/rooms/go/proto"
+	"github.com/gitgernit/videochat-rooms/internal/domain/rooms"
+	"github.com/gitgernit/videochat-rooms/internal/domain/signaling"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/auth"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/invites"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/rooms/repositories/memory"
+	"github.com/gitgernit/videochat-rooms/internal/infrastructure/signaling/turn"
+	transport "github.com/gitgernit/videochat-rooms/internal/transport/grpc"
+	"github.com/gitgernit/videochat-rooms/internal/transport/grpc/hub"
+	"github.com/gitgernit/videochat-rooms/pkg/logger"
+	"github.com/gitgernit/videochat-rooms/pkg/pubsub"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParseCandidate(t *testing.T) {
+	c, err := signaling.ParseCandidate("candidate:842163049 1 udp 1677729535 203.0.113.7 46154 typ srflx raddr 10.0.0.2 rport 46154 generation 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != signaling.CandidateSrflx || c.Address != "203.0.113.7" || c.Port != 46154 || c.RelatedAddress != "10.0.0.2" {
+		t.Fatalf("unexpected candidate %+v", c)
+	}
+
+	invalid := []string{
+		"",
+		"candidate:1 1 udp 2122260223 10.0.0.2 54321 host",
+		"candidate:1 0 udp 2122260223 10.0.0.2 54321 typ host",
+		"candidate:1 1 sctp 2122260223 10.0.0.2 54321 typ host",
+		"candidate:1 1 udp 2122260223 10.0.0.2 70000 typ host",
+		"candidate:1 1 udp 2122260223 10.0.0.2 54321 typ local",
+	}
+	for _, line := range invalid {
+		if _, err := signaling.ParseCandidate(line); !errors.Is(err, signaling.ErrInvalidCandidate) {
+			t.Errorf("expected %q to be invalid, got %v", line, err)
+		}
+	}
+}
+
+func TestIceCandidateRelay(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Options{HMACSecret: testJWTSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, _, client := newSessionTestServer(t, grpc.ChainStreamInterceptor(transport.AuthStreamInterceptor(verifier)))
+
+	bearer := func(sub, name string) string {
+		return "Bearer " + signHS256(t, jwt.MapClaims{"sub": sub, "preferred_username": name, "exp": time.Now().Add(time.Minute).Unix()})
+	}
+	aliceID, bobID := uuid.New(), uuid.New()
+
+	// Alice owns a lobby room, so Bob waits until she admits him.
+	ownerCtx := auth.WithIdentity(context.Background(), auth.Identity{Subject: aliceID.String(), Username: "alice"})
+	room, err := service.CreateRoom(metadata.NewIncomingContext(ownerCtx, metadata.Pairs("room-lobby", "true")), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := joinRoom(t, context.Background(), client, "authorization", bearer(aliceID.String(), "alice"), "room_name", room.Name)
+
+	sendCandidate := func(candidate string) {
+		t.Helper()
+
+		err := alice.Send(&proto.RoomMethod{Method: &proto.RoomMethod_SendIceCandidate{
+			SendIceCandidate: &proto.SendIceCandidate{Candidate: candidate},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sendCandidate(`{"to": "` + bobID.String() + `", "candidate": "candidate:1 1 udp 2122260223 10.0.0.2 54321 typ host"}`)
+	msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, ok := dispatched(msg)
+		return ok && event.Type == "error"
+	})
+	if event, _ := dispatched(msg); event.Reason != "INVALID_CANDIDATE" {
+		t.Fatalf("expected a candidate without sdpMid to be rejected, got %+v", event)
+	}
+
+	// Candidates for users the room does not know are not kept.
+	sendCandidate(`{"to": "` + uuid.NewString() + `", "candidate": "candidate:1 1 udp 2122260223 10.0.0.2 54321 typ host", "sdpMid": "0"}`)
+	msg = recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, ok := dispatched(msg)
+		return ok && event.Type == "error"
+	})
+	if event, _ := dispatched(msg); event.Reason != "UNKNOWN_TARGET" {
+		t.Fatalf("expected a candidate for a stranger to be rejected, got %+v", event)
+	}
+
+	bob, err := client.JoinRoom(metadata.AppendToOutgoingContext(context.Background(), "authorization", bearer(bobID.String(), "bob"), "room_name", room.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		event, ok := dispatched(msg)
+		return ok && event.Type == "join_request"
+	})
+
+	// Bob waits in the lobby, so the candidate waits for him.
+	sendCandidate(`{"to": "` + bobID.String() + `", "candidate": "candidate:1 1 udp 2122260223 10.0.0.2 54321 typ host", "sdpMid": "0", "sdpMLineIndex": 0}`)
+	sendText(t, alice, "/admit "+bobID.String())
+
+	received := iceCandidate(t, bob)
+	if received.From != aliceID.String() || received.SdpMid == nil || *received.SdpMid != "0" || received.EndOfCandidates {
+		t.Fatalf("unexpected relayed candidate %+v", received)
+	}
+
+	sendCandidate(`{"to": "` + bobID.String() + `", "candidate": ""}`)
+	if received := iceCandidate(t, bob); !received.EndOfCandidates {
+		t.Fatalf("expected end of candidates, got %+v", received)
+	}
+}
+
+type relayedCandidate struct {
+	Candidate       string  `json:"candidate"`
+	SdpMid          *string `json:"sdpMid"`
+	EndOfCandidates bool    `json:"endOfCandidates"`
+	From            string  `json:"from"`
+}
+
+func iceCandidate(t *testing.T, stream proto.RoomsService_JoinRoomClient) relayedCandidate {
+	t.Helper()
+
+	msg := recvUntil(t, stream, func(msg *proto.RoomMethod) bool { return msg.GetIceCandidateReceived() != nil })
+
+	candidate := relayedCandidate{}
+	if err := json.Unmarshal([]byte(msg.GetIceCandidateReceived().Candidate), &candidate); err != nil {
+		t.Fatal(err)
+	}
+
+	return candidate
+}
+
+func TestSdpAddressing(t *testing.T) {
+	service, _, client := newSessionTestServer(t)
+
+	room, err := service.CreateRoom(context.Background(), &proto.CreateRoomRequest{Name: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, aliceHeader := joinRoom(t, context.Background(), client, "username", "alice", "room_name", room.Name)
+	bob, bobHeader := joinRoom(t, context.Background(), client, "username", "bob", "room_name", room.Name)
+	aliceID, bobID := aliceHeader.Get("user-id")[0], bobHeader.Get("user-id")[0]
+	stranger := uuid.NewString()
+
+	err = alice.Send(&proto.RoomMethod{Method: &proto.RoomMethod_SendSdp{SendSdp: &proto.SendSDP{Sdp: []*proto.SDP{
+		{Type: "offer", Sdp: testOffer, Username: stranger},
+		{Type: "offer", Sdp: testOffer, Username: "bob"},
+		{Type: "offer", Sdp: testOffer, Username: bobID},
+	}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := recvUntil(t, bob, func(msg *proto.RoomMethod) bool { return msg.GetSdpReceived() != nil })
+	if sdp := msg.GetSdpReceived(); sdp.From != aliceID || sdp.To != bobID {
+		t.Fatalf("unexpected sdp %+v", sdp)
+	}
+
+	reasons := map[string]string{}
+	for len(reasons) < 2 {
+		msg := recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+			event, ok := dispatched(msg)
+			return ok && event.Type == "error"
+		})
+
+		event, _ := dispatched(msg)
+		target := struct {
+			To string `json:"to"`
+		}{}
+		if err := json.Unmarshal(event.Data, &target); err != nil {
+			t.Fatal(err)
+		}
+		reasons[target.To] = event.Reason
+	}
+	if reasons[stranger] != "UNKNOWN_TARGET" {
+		t.Fatalf("expected an unknown target error for %s, got %v", stranger, reasons)
+	}
+	if _, ok := reasons["bob"]; !ok {
+		t.Fatalf("expected an error for addressing by username, got %v", reasons)
+	}
+
+	// Bad targets leave the sender's stream open.
+	sendText(t, alice, "still here")
+	recvUntil(t, alice, func(msg *proto.RoomMethod) bool {
+		return msg.GetMessageReceived().GetText() == "still here"
+	})
+}
+
+const testOffer = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=extmap-allow-mixed\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:0\r\n" +
+	"a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"b=AS:5000\r\n" +
+	"a=mid:1\r\n" +
+	"a=extmap:2 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtcp-fb:96 nack\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:98 H264/90000\r\n" +
+	"a=fmtp:98 profile-level-id=42e01f\r\n" +
+	"a=rtpmap:99 rtx/90000\r\n" +
+	"a=fmtp:99 apt=98\r\n"
+
+func TestSDPPolicy(t *testing.T) {
+	unchanged, err := signaling.Policy{}.Enforce(signaling.SDPOffer, testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != testOffer {
+		t.Fatalf("expected the zero policy to keep the sdp as is, got\n%s", unchanged)
+	}
+
+	policy := signaling.Policy{
+		Codecs:               []string{"H264", "opus", "rtx"},
+		MaxBitrate:           1000,
+		DisallowedExtensions: []string{"http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"},
+	}
+
+	enforced, err := policy.Enforce(signaling.SDPOffer, testOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := signaling.ParseSDP(enforced)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio, video := desc.Media[0], desc.Media[1]
+	if !slices.Equal(audio.Formats, []string{"111"}) {
+		t.Fatalf("expected only opus, got %v", audio.Formats)
+	}
+	if !slices.Equal(video.Formats, []string{"98", "99"}) {
+		t.Fatalf("expected H264 and its rtx, got %v", video.Formats)
+	}
+	if strings.Contains(enforced, "VP8") || strings.Contains(enforced, "abs-send-time") || strings.Contains(enforced, "apt=96") {
+		t.Fatalf("expected VP8 and abs-send-time to be stripped, got\n%s", enforced)
+	}
+	if !strings.Contains(enforced, "b=AS:1000\r\n") || strings.Contains(enforced, "b=AS:5000") {
+		t.Fatalf("expected bitrate to be capped, got\n%s", enforced)
+	}
+
+	invalid := []struct {
+		policy signaling.Policy
+		sdp    string
+		err    error
+	}{
+		{signaling.Policy{}, "o=- 1 2 IN IP4 127.0.0.1\r\n", signaling.ErrInvalidSDP},
+		{signaling.Policy{}, strings.Replace(testOffer, "t=0 0\r\n", "", 1), signaling.ErrInvalidSDP},
+		{signaling.Policy{}, strings.Replace(testOffer, "m=audio 9", "m=audio x", 1), signaling.ErrInvalidSDP},
+		{signaling.Policy{Codecs: []string{"AV1"}}, testOffer, signaling.ErrNoAllowedCodec},
+		{signaling.Policy{Codecs: []string{"opus", "rtx"}}, testOffer, signaling.ErrNoAllowedCodec},
+		{signaling.Policy{MaxMediaSections: 1}, testOffer, signaling.ErrTooManyMediaSections},
+	}
+	for i, tc := range invalid {
+		if _, err := tc.policy.Enforce(signaling.SDPOffer, tc.sdp); !errors.Is(err, tc.err) {
+			t.Errorf("case %d: expected %v, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestCandidatePolicy(t *testing.T) {
+	host := "candidate:1 1 udp 2122260223 192.168.1.2 54321 typ host"
+	srflx := "candidate:2 1 udp 1686052607 203.0.113.7 54321 typ srflx raddr 192.168.1.2 rport 54321"
+	relay := "candidate:3 1 udp 41885439 198.51.100.1 3478 typ relay raddr 203.0.113.7 rport 54321"
+
+	if _, keep, _ := signaling.CandidatesNoHost.FilterCandidate(host); keep {
+		t.Fatal("expected no-host to drop host candidates")
+	}
+	filtered, keep, err := signaling.CandidatesNoHost.FilterCandidate(srflx)
+	if err != nil || !keep {
+		t.Fatalf("expected no-host to keep srflx candidates, got %v", err)
+	}
+	if strings.Contains(filtered, "192.168.1.2") {
+		t.Fatalf("expected the related address to be hidden, got %q", filtered)
+	}
+	if _, keep, _ := signaling.CandidatesRelay.FilterCandidate(srflx); keep {
+		t.Fatal("expected relay to drop srflx candidates")
+	}
+	if unchanged, keep, _ := signaling.CandidatesAll.FilterCandidate(srflx); !keep || unchanged != srflx {
+		t.Fatalf("expected all to keep candidates as they are, got %q", unchanged)
+	}
+
+	offer := strings.Replace(testOffer, "c=IN IP4 0.0.0.0\r\n", "c=IN IP4 192.168.1.2\r\na=candidate:"+strings.TrimPrefix(host, "candidate:")+"\r\na="+srflx+"\r\na="+relay+"\r\n", 1)
+	enforced, err := signaling.Policy{Candidates: signaling.CandidatesRelay}.Enforce(signaling.SDPOffer, offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"192.168.1.2", "203.0.113.7"} {
+		if strings.Contains(enforced, leaked) {
+			t.Fatalf("expected %s to be hidden, got\n%s", leaked, enforced)
+		}
+	}
+	if !strings.Contains(enforced, "198.51.100.1 3478 typ relay") {
+		t.Fatalf("expected the relay candidate to be kept, got\n%s", enforced)
+	}
+
+	interactor := rooms.NewInteractor(logger.New(zap.DebugLevel, "test"), memory.NewRepository(), pubsub.New[rooms.Event](pubsub.DefaultBufferSize), invites.NewHMAC([]byte("test")), rooms.Config{DefaultCandidatePolicy: signaling.CandidatesNoHost})
+
+	lax, err := interactor.CreateRoom(context.Background(), rooms.CreateRoomParams{Name: "lax", Settings: rooms.Settings{CandidatePolicy: signaling.CandidatesAll}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lax.Settings.CandidatePolicy != signaling.CandidatesNoHost {
+		t.Fatalf("expected rooms not to relax the server policy, got %s", lax.Settings.CandidatePolicy)
+	}
+
+	strict, err := interactor.CreateRoom(context.Background(), rooms.CreateRoomParams{Name: "strict", Settings: rooms.Settings{CandidatePolicy: signaling.CandidatesRelay}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strict.Settings.CandidatePolicy != signaling.CandidatesRelay {
+		t.Fatalf("expected a relay only room, got %s", strict.Settings.CandidatePolicy)
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("turn-secret")
+
+	repository := memory.NewRepository()
+	events := pubsub.New[rooms.Event](pubsub.DefaultBufferSize)
+	service := transport.NewRoomsService(logger.New(zap.DebugLevel, "test"), repository, events, invites.NewHMAC([]byte("test")), auth.AllowAll{}, hub.New(hub.Options{}), transport.SessionOptions{}, transport.SignalingOptions{
+		STUNURLs: []string{"stun:stun.example.com:3478"},
+		TURNURLs: []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349"},
+		TURN:     turn.NewREST(secret, time.Hour),
+	}, rooms.Config{})
+
+	mux := runtime.NewServeMux()
+	if err := transport.RegisterGatewayRoutes(mux, service, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	interactor := rooms.NewInteractor(logger.New(zap.DebugLevel, "test"), repository, events, invites.NewHMAC([]byte("test")), rooms.Config{})
+	room, err := interactor.CreateRoom(ctx, rooms.CreateRoomParams{Name: "call"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := interactor.JoinRoom(ctx, room.Id, rooms.User{Id: uuid.New(), Name: "alice"}, rooms.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(username string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/rooms/"+room.Id+"/ice-servers", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Username", username)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	if resp := get("mallory"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected non members to be refused, got %s", resp.Status)
+	}
+
+	resp := get("alice")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ice servers, got %s", resp.Status)
+	}
+
+	var body struct {
+		IceServers []struct {
+			URLs       []string `json:"urls"`
+			Username   string   `json:"username"`
+			Credential string   `json:"credential"`
+		} `json:"ice_servers"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.IceServers) != 2 || body.IceServers[0].Username != "" || len(body.IceServers[1].URLs) != 2 {
+		t.Fatalf("unexpected ice servers %+v", body.IceServers)
+	}
+
+	credentials := body.IceServers[1]
+	expiry, user, _ := strings.Cut(credentials.Username, ":")
+	if expiry != strconv.FormatInt(body.ExpiresAt.Unix(), 10) || user != alice.Id.String() {
+		t.Fatalf("unexpected turn username %q", credentials.Username)
+	}
+	if remaining := time.Until(body.ExpiresAt); remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("unexpected expiry %s", body.ExpiresAt)
+	}
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write([]byte(credentials.Username))
+	if credentials.Credential != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("credential does not follow the turn rest scheme: %q", credentials.Credential)
+	}
+}